@@ -4,4 +4,4 @@ go 1.19
 
 require github.com/flowdev/gflowparser v0.0.0-20191030141552-27881c9af567
 
-require github.com/flowdev/gparselib v0.0.0-20190826175941-49986cd3c0ee // indirect
+require github.com/flowdev/gparselib v0.0.0-20190826175941-49986cd3c0ee
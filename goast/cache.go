@@ -0,0 +1,189 @@
+package goast
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/flowdev/gflowparser"
+	"github.com/flowdev/gflowparser/data"
+)
+
+// Version is the version of go2md.
+// It is part of all cache keys, so a new version regenerates everything.
+const Version = "0.2.0"
+
+const cacheFileName = ".go2md-cache"
+
+// flowCache remembers the converted flows of the last run.
+// It is safe for concurrent use.
+type flowCache struct {
+	mutex      sync.Mutex
+	file       string
+	optsKey    string
+	old        cacheData
+	new        cacheData
+	fileHashes map[string]string
+}
+
+// cacheData is the content of the cache file.
+type cacheData struct {
+	Flows map[string]*cacheEntry `json:"flows"`
+}
+
+// cacheEntry is the result of converting a single flow.
+type cacheEntry struct {
+	SVG       []byte      `json:"svg"`
+	CompTypes []data.Type `json:"compTypes"`
+	DataTypes []data.Type `json:"dataTypes"`
+}
+
+// newFlowCache creates an empty cache.
+// The cache is kept in memory only if the file name is empty.
+func newFlowCache(file string, opts Options) *flowCache {
+	opts.Cache, opts.Clean, opts.DryRun, opts.Jobs = false, false, false, 0 // don't change the output
+	return &flowCache{
+		file:       file,
+		optsKey:    fmt.Sprintf("%s|%+v", Version, opts),
+		new:        cacheData{Flows: make(map[string]*cacheEntry)},
+		fileHashes: make(map[string]string),
+	}
+}
+
+// loadCache loads the cache file. A missing or broken cache file results
+// in an empty cache.
+func loadCache(file string, opts Options) *flowCache {
+	c := newFlowCache(file, opts)
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return c
+	}
+	if err = json.Unmarshal(buf, &c.old); err != nil {
+		log.Printf("WARNING: Ignoring broken cache file '%s': %v", file, err)
+		c.old = cacheData{}
+	}
+	return c
+}
+
+// flowKey computes the cache key of a flow from the version of go2md, the
+// options, the Go file containing the flow and the flow DSL itself.
+func (c *flowCache) flowKey(f *sourcePart, dsl string) string {
+	h := sha256.New()
+	h.Write([]byte(c.optsKey + "\x00" + c.fileHash(f.goFile) + "\x00" + f.name + "\x00" + dsl))
+	return hex.EncodeToString(h.Sum(nil))
+}
+func (c *flowCache) fileHash(goFile string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if hash, ok := c.fileHashes[goFile]; ok {
+		return hash
+	}
+	hash := ""
+	if buf, err := ioutil.ReadFile(goFile); err == nil {
+		sum := sha256.Sum256(buf)
+		hash = hex.EncodeToString(sum[:])
+	}
+	c.fileHashes[goFile] = hash
+	return hash
+}
+func (c *flowCache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e := c.old.Flows[key]
+	if e == nil {
+		e = c.new.Flows[key]
+	}
+	if e != nil {
+		c.new.Flows[key] = e
+	}
+	return e
+}
+func (c *flowCache) put(key string, e *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.new.Flows[key] = e
+}
+
+// next starts a new run with the flows of the last run.
+// The content of all files is hashed again.
+func (c *flowCache) next() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.old = c.new
+	c.new = cacheData{Flows: make(map[string]*cacheEntry)}
+	c.fileHashes = make(map[string]string)
+}
+
+// save writes all flows of this run to the cache file.
+func (c *flowCache) save() error {
+	if c.file == "" {
+		return nil
+	}
+	buf, err := marshalJSON(c.new, "")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.file, buf, os.FileMode(0666))
+}
+
+// convertedFlow is the result of converting a single flow.
+type convertedFlow struct {
+	svg       []byte
+	compTypes []data.Type
+	dataTypes []data.Type
+	err       error
+}
+
+// convertFlows converts all given flows concurrently.
+// The results are in the same order as the flows.
+func convertFlows(flows []*sourcePart, packDict *packageDict) []*convertedFlow {
+	convs := make([]*convertedFlow, len(flows))
+	wg := sync.WaitGroup{}
+	for i, f := range flows {
+		wg.Add(1)
+		go func(i int, f *sourcePart) {
+			defer wg.Done()
+			dsl := f.dsl
+			conv := &convertedFlow{}
+			packDict.work(func() {
+				conv.svg, conv.compTypes, conv.dataTypes, conv.err = convertFlowDSL(f, dsl, packDict.cache)
+			})
+			convs[i] = conv
+		}(i, f)
+	}
+	wg.Wait()
+	return convs
+}
+
+// convertFlowDSL converts the flow DSL to SVG and finds all component and
+// data types. The result is taken from the cache if possible.
+func convertFlowDSL(f *sourcePart, dsl string, c *flowCache) (svg []byte, compTypes, dataTypes []data.Type, err error) {
+	key := ""
+	if c != nil {
+		key = c.flowKey(f, dsl)
+		if e := c.get(key); e != nil {
+			fmt.Println("Using cached flow:", f.name)
+			return e.SVG, copyTypes(e.CompTypes), copyTypes(e.DataTypes), nil
+		}
+	}
+	log.Printf("Converting FlowDSL: '%s'\n", dsl)
+	svg, compTypes, dataTypes, info, err := gflowparser.ConvertFlowDSLToSVG(dsl, f.name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if info != "" {
+		log.Printf("INFO: %s", info)
+	}
+	if c != nil {
+		c.put(key, &cacheEntry{SVG: svg, CompTypes: copyTypes(compTypes), DataTypes: copyTypes(dataTypes)})
+	}
+	return svg, compTypes, dataTypes, nil
+}
+func copyTypes(types []data.Type) []data.Type {
+	return append(make([]data.Type, 0, len(types)), types...)
+}
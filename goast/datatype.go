@@ -0,0 +1,189 @@
+package goast
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// Kinds of type definitions.
+const (
+	typeKindStruct    = "struct"
+	typeKindInterface = "interface"
+)
+
+// typeDef is the definition of a data type.
+// Other types than structs and interfaces only have an underlying type.
+type typeDef struct {
+	kind       string
+	underlying typeExpr
+	members    []typeMember      // fields of structs or methods of interfaces
+	summary    string            // first line of the doc comment
+	imps       map[string]string // imports of the declaring file
+}
+
+// typeMember is a field of a struct or a method of an interface.
+// The name is empty for embedded types.
+type typeMember struct {
+	name string
+	typ  typeExpr
+	doc  string
+}
+
+// typeExpr is a type expression with the named types that it references.
+type typeExpr struct {
+	expr string
+	refs []data.Type
+}
+
+func newTypeDef(spec *ast.TypeSpec, doc *ast.CommentGroup, imps map[string]string) *typeDef {
+	def := &typeDef{summary: strings.TrimSpace(strings.SplitN(doc.Text(), "\n", 2)[0]), imps: imps}
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		def.kind = typeKindStruct
+		for _, field := range t.Fields.List {
+			def.members = append(def.members, newTypeMembers(field, false)...)
+		}
+	case *ast.InterfaceType:
+		def.kind = typeKindInterface
+		for _, field := range t.Methods.List {
+			def.members = append(def.members, newTypeMembers(field, true)...)
+		}
+	default:
+		def.underlying = newTypeExpr(spec.Type)
+	}
+	return def
+}
+
+func newTypeMembers(field *ast.Field, method bool) []typeMember {
+	typ := newTypeExpr(field.Type)
+	if method {
+		typ.expr = strings.TrimPrefix(typ.expr, "func")
+	}
+	doc := field.Doc.Text()
+	if doc == "" {
+		doc = field.Comment.Text()
+	}
+	doc = strings.Join(strings.Fields(doc), " ")
+	if len(field.Names) == 0 {
+		return []typeMember{{typ: typ, doc: doc}}
+	}
+	members := make([]typeMember, len(field.Names))
+	for i, n := range field.Names {
+		members[i] = typeMember{name: n.Name, typ: typ, doc: doc}
+	}
+	return members
+}
+
+// newTypeExpr finds all named types in the expression that aren't
+// predeclared.
+func newTypeExpr(expr ast.Expr) typeExpr {
+	te := typeExpr{expr: types.ExprString(expr)}
+	seen := make(map[data.Type]bool)
+	add := func(t data.Type) {
+		if !seen[t] {
+			seen[t] = true
+			te.refs = append(te.refs, t)
+		}
+	}
+	var inspect func(n ast.Node) bool
+	inspect = func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.Field: // don't mistake names of parameters for types
+			ast.Inspect(x.Type, inspect)
+			return false
+		case *ast.SelectorExpr:
+			if pkg, ok := x.X.(*ast.Ident); ok {
+				add(data.Type{Package: pkg.Name, LocalType: x.Sel.Name})
+			}
+			return false
+		case *ast.Ident:
+			if types.Universe.Lookup(x.Name) == nil {
+				add(data.Type{LocalType: x.Name})
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, inspect)
+	return te
+}
+
+// typeEntry is a data type with its definition and the links of the types
+// it references.
+type typeEntry struct {
+	link  link
+	def   *typeDef
+	links map[data.Type]link
+}
+
+// typeEntries returns the linked data types and all project types that are
+// referenced by them recursively.
+func typeEntries(dataLinks []link, partMap map[string]*sourcePart, mdFile *mdFile) []typeEntry {
+	entries := make([]typeEntry, 0, len(dataLinks))
+	seen := make(map[*sourcePart]bool)
+	queue := append(make([]link, 0, len(dataLinks)), dataLinks...)
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if l.part == nil || l.part.def == nil || seen[l.part] {
+			continue
+		}
+		seen[l.part] = true
+		e := typeEntry{link: l, def: l.part.def, links: make(map[data.Type]link)}
+		for _, te := range l.part.def.exprs() {
+			for _, ref := range te.refs {
+				rl := linkForTypeRef(ref, l.part, partMap, mdFile)
+				if rl.url != "" {
+					e.links[ref] = rl
+					queue = append(queue, rl)
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// linkForTypeRef returns the link for a type referenced in the definition of
+// the owner type.
+// Package qualified types are resolved with the imports of the file that
+// declares the owner type.
+func linkForTypeRef(ref data.Type, owner *sourcePart, partMap map[string]*sourcePart, mdFile *mdFile) link {
+	path := owner.importPath
+	if ref.Package != "" {
+		if path = owner.def.imps[ref.Package]; path == "" {
+			return link{name: typeToString(ref)}
+		}
+	} else if path == "" {
+		return linkForTypePart(ref.LocalType, partMap[markerType+ref.LocalType], mdFile)
+	}
+	fi := mdFile.fImps
+	refMap := fi.packDict.partMapFor(path, func() map[string]*sourcePart {
+		return fi.findPartsForPath(path)
+	})
+	return linkForTypePart(typeToString(ref), refMap[markerType+ref.LocalType], mdFile)
+}
+
+func (def *typeDef) exprs() []typeExpr {
+	if def.kind == "" {
+		return []typeExpr{def.underlying}
+	}
+	exprs := make([]typeExpr, len(def.members))
+	for i, m := range def.members {
+		exprs[i] = m.typ
+	}
+	return exprs
+}
+
+// refLinks returns the links of the project types in the expression.
+func (e typeEntry) refLinks(te typeExpr) []link {
+	links := make([]link, 0, len(te.refs))
+	for _, ref := range te.refs {
+		if l, ok := e.links[ref]; ok {
+			links = append(links, l)
+		}
+	}
+	return links
+}
@@ -0,0 +1,174 @@
+package goast
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// Formats for exporting flow graphs.
+const (
+	ExportDOT      = "dot"
+	ExportPlantUML = "puml"
+)
+
+// graphWriter writes a flow graph in one export format.
+type graphWriter func(b *strings.Builder, prefix string, g *flowGraph, urls map[string]string)
+
+// exportFlow writes the flow graph in all the requested export formats.
+func exportFlow(f *sourcePart) error {
+	for _, format := range f.mdFile.fImps.packDict.opts.Exports {
+		b := strings.Builder{}
+		switch format {
+		case ExportDOT:
+			b.WriteString(fmt.Sprintf("digraph %s {\n\trankdir=LR;\n", dotString(f.name)))
+			b.WriteString("\tnode [shape=box, style=rounded];\n")
+			graphToDOT(&b, "", f.graph, f.graphURLs)
+			b.WriteString("}\n")
+		case ExportPlantUML:
+			b.WriteString("@startuml " + f.name + "\nleft to right direction\n")
+			graphToPlantUML(&b, "", f.graph, f.graphURLs)
+			b.WriteString("@enduml\n")
+		}
+		if err := f.mdFile.fImps.packDict.writeFile(
+			filepath.Join(filepath.Dir(f.mdFile.name), f.name+"."+format), []byte(b.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportPackage writes the graphs of all flows of a package into one file
+// per requested export format.
+func exportPackage(pkgName, dir string, flows []*sourcePart, packDict *packageDict) error {
+	for _, format := range packDict.opts.Exports {
+		b := strings.Builder{}
+		switch format {
+		case ExportDOT:
+			b.WriteString(fmt.Sprintf("digraph %s {\n\trankdir=LR;\n", dotString(pkgName)))
+			b.WriteString("\tnode [shape=box, style=rounded];\n")
+			for _, f := range flows {
+				if f.graph == nil {
+					continue
+				}
+				b.WriteString(fmt.Sprintf("\tsubgraph %s {\n\t\tlabel=%s;\n",
+					dotString("cluster_"+f.name), dotString(f.name)))
+				graphToDOT(&b, f.name+"_", f.graph, f.graphURLs)
+				b.WriteString("\t}\n")
+			}
+			b.WriteString("}\n")
+		case ExportPlantUML:
+			b.WriteString("@startuml " + pkgName + "\nleft to right direction\n")
+			for _, f := range flows {
+				if f.graph == nil {
+					continue
+				}
+				b.WriteString(fmt.Sprintf("rectangle %q {\n", f.name))
+				graphToPlantUML(&b, f.name+"_", f.graph, f.graphURLs)
+				b.WriteString("}\n")
+			}
+			b.WriteString("@enduml\n")
+		}
+		if err := packDict.writeFile(filepath.Join(dir, pkgName+"."+format), []byte(b.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func graphToDOT(b *strings.Builder, prefix string, g *flowGraph, urls map[string]string) {
+	indent := "\t"
+	if prefix != "" {
+		indent = "\t\t"
+	}
+	for _, n := range g.nodes {
+		attrs := "label=" + dotString(strings.Join(n.label, "\n"))
+		if n.kind != flowNodeComponent {
+			attrs += ", shape=circle"
+		}
+		if url := urls[n.id]; url != "" {
+			attrs += ", URL=" + dotString(url)
+		}
+		b.WriteString(indent + dotString(prefix+n.id) + " [" + attrs + "];\n")
+	}
+	for _, e := range g.edges {
+		b.WriteString(indent + dotString(prefix+e.from.id) + " -> " + dotString(prefix+e.to.id))
+		if l := e.label(); l != "" {
+			b.WriteString(" [label=" + dotString(l) + "]")
+		}
+		b.WriteString(";\n")
+	}
+}
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+func graphToPlantUML(b *strings.Builder, prefix string, g *flowGraph, urls map[string]string) {
+	for _, n := range g.nodes {
+		kind := "rectangle"
+		if n.kind != flowNodeComponent {
+			kind = "circle"
+		}
+		b.WriteString(fmt.Sprintf("%s %s as %s", kind, pumlString(strings.Join(n.label, "\n")), prefix+n.id))
+		if url := urls[n.id]; url != "" {
+			b.WriteString(" [[" + url + "]]")
+		}
+		b.WriteString("\n")
+	}
+	for _, e := range g.edges {
+		b.WriteString(prefix + e.from.id + " --> " + prefix + e.to.id)
+		if l := e.label(); l != "" {
+			b.WriteString(" : " + l)
+		}
+		b.WriteString("\n")
+	}
+}
+func pumlString(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `"`, `'`), "\n", `\n`) + `"`
+}
+
+// sourceURLsFor finds the URLs of the source code of all components of a
+// flow graph.
+func sourceURLsFor(g *flowGraph, partMap map[string]*sourcePart, mdFile *mdFile) map[string]string {
+	urls := make(map[string]string, len(g.nodes))
+	for _, n := range g.nodes {
+		if n.kind != flowNodeComponent {
+			continue
+		}
+		if l := getSourceLinkForComponent(n.comp, partMap, mdFile); l.url != "" {
+			urls[n.id] = l.url
+		}
+	}
+	return urls
+}
+
+// getSourceLinkForComponent links to the source code of a flow or function
+// instead of its documentation.
+func getSourceLinkForComponent(comp data.Type, partMap map[string]*sourcePart, mdFile *mdFile) link {
+	var part *sourcePart
+	cNam := typeToString(comp)
+	if comp.Package == "" {
+		part = partMap[markerFlow+comp.LocalType]
+		if part == nil {
+			part = partMap[markerFunc+comp.LocalType]
+		}
+	} else {
+		part = mdFile.fImps.getPartFor(comp.Package, markerFlow+comp.LocalType)
+		if part == nil {
+			part = mdFile.fImps.getPartFor(comp.Package, markerFunc+comp.LocalType)
+		}
+	}
+	if part == nil {
+		return link{name: cNam}
+	}
+	fileName, err := fileNameFor(part, markerFunc, mdFile)
+	if err != nil {
+		fmt.Println("WARNING: Unable to compute correct URL for component", cNam, ":", err)
+		fileName = part.goFile
+	}
+	return link{name: cNam, url: fmt.Sprintf("%s#L%dL%d", fileName, part.start, part.end), part: part}
+}
@@ -0,0 +1,95 @@
+package goast
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const flowFileExt = ".flow"
+
+// findFlowFiles finds the flows in all flow files of the directory.
+// A flow file starts with a header line that contains the flow marker
+// followed by the name of the flow: 'flow: MyFlow'.
+// All other lines contain the flow DSL.
+// If the package contains a Go function with the same name, the flow
+// documents that function like a flow in its doc comment would do.
+// Else the flow gets its own documentation file.
+func findFlowFiles(
+	partMap map[string]*sourcePart, flows []*sourcePart,
+	dir string, path string,
+) ([]*sourcePart, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+flowFileExt))
+	if err != nil {
+		return flows, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return flows, fmt.Errorf("unable to read flow file: %w", err)
+		}
+		name, dsl, lines, err := parseFlowFile(string(buf))
+		if err != nil {
+			return flows, fmt.Errorf("unable to parse flow file '%s': %w", file, err)
+		}
+		if partMap[markerFlow+name] != nil {
+			return flows, fmt.Errorf("flow '%s' of file '%s' is defined twice", name, file)
+		}
+		flow := &sourcePart{
+			kind:       sourcePartFlow,
+			name:       name,
+			start:      1,
+			end:        lines,
+			importPath: path,
+			goFile:     file,
+			mdFile:     &mdFile{name: goNameToBase(file)},
+			dsl:        dsl,
+			flowFile:   file,
+		}
+		if fun := partMap[markerFunc+name]; fun != nil { // bind the flow to its function
+			flow.doc = fun.doc
+			flow.docStart = fun.doc
+			flow.start, flow.end = fun.start, fun.end
+			flow.goFile = fun.goFile
+			flow.sig = fun.sig
+			flow.mdFile = &mdFile{name: goNameToBase(fun.goFile)}
+			delete(partMap, markerFunc+name)
+		}
+		partMap[markerFlow+name] = flow
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+// parseFlowFile returns the name of the flow, the DSL and the number of
+// lines of the flow file.
+func parseFlowFile(content string) (name, dsl string, lines int, err error) {
+	all := strings.SplitAfter(content, "\n")
+	if all[len(all)-1] == "" {
+		all = all[:len(all)-1]
+	}
+	i := 0
+	for i < len(all) && strings.TrimSpace(all[i]) == "" {
+		i++
+	}
+	if i == len(all) {
+		return "", "", 0, fmt.Errorf("missing header line 'flow: <name>'")
+	}
+	m := flowMarkerRegex.FindStringSubmatch(strings.TrimSpace(all[i]))
+	if m == nil || m[1] == "" {
+		return "", "", 0, fmt.Errorf("expected header line 'flow: <name>' in line %d but got: %s",
+			i+1, strings.TrimSpace(all[i]))
+	}
+	body := all[i+1:]
+	for len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+		body = body[1:]
+	}
+	dsl = joinDocLines(body)
+	if dsl == "" {
+		return "", "", 0, fmt.Errorf("missing flow DSL after the header line")
+	}
+	return m[1], dsl, len(all), nil
+}
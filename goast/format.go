@@ -0,0 +1,236 @@
+package goast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// FormatDirs formats the flows in the comments of all Go files in the given
+// directories. Only changed files are written.
+func FormatDirs(dirs []string, dryRun bool) error {
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			if strings.HasSuffix(file, goTestFileName) {
+				continue
+			}
+			if err = formatFile(file, dryRun); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+func formatFile(file string, dryRun bool) error {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	res, err := FormatSource(file, src)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if dryRun {
+		fmt.Println("Would format:", file)
+		return nil
+	}
+	fmt.Println("Formatting:", file)
+	return ioutil.WriteFile(file, res, os.FileMode(0666))
+}
+
+// FormatSource formats the flows in the comments of the Go source code.
+// The DSL gets a canonical layout and is indented like a code block of a
+// doc comment, so gofmt won't change it anymore.
+// Everything before and after the DSL stays untouched.
+// Flows that can't be parsed or whose meaning would change are left alone.
+func FormatSource(fileName string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	astf, err := parser.ParseFile(fset, fileName, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(astf.Pos())
+	res := append([]byte{}, src...)
+	for i := len(astf.Comments) - 1; i >= 0; i-- { // from the end so offsets stay valid
+		cg := astf.Comments[i]
+		if blocks, _ := extractFlows(cg.Text()); len(blocks) == 0 {
+			continue
+		}
+		if strings.HasPrefix(cg.List[0].Text, "/*") {
+			log.Printf("WARNING: %s: Unable to format flow in block comment.", fset.Position(cg.Pos()))
+			continue
+		}
+		start, end := tf.Offset(cg.Pos()), tf.Offset(cg.End())
+		lineStart := bytes.LastIndexByte(src[:start], '\n') + 1
+		indent := string(src[lineStart:start])
+		formatted, err := formatFlowComment(string(src[start:end]), indent)
+		if err != nil {
+			log.Printf("WARNING: %s: Unable to format flow: %v", fset.Position(cg.Pos()), err)
+			continue
+		}
+		res = append(res[:start:start], append([]byte(formatted), res[end:]...)...)
+	}
+	return res, nil
+}
+
+// formatFlowComment formats the flow in a group of line comments.
+func formatFlowComment(comment, indent string) (string, error) {
+	lines := strings.Split(comment, "\n")
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = commentText(line)
+	}
+	result := make([]string, 0, len(lines)+4)
+	for i := 0; i < len(lines); i++ {
+		result = append(result, strings.TrimLeft(lines[i], " \t"))
+		if !flowMarkerRegex.MatchString(texts[i]) ||
+			(i > 0 && strings.TrimSpace(texts[i-1]) != "") {
+			continue
+		}
+		j := i + 1
+		dsl := make([]string, 0, 8)
+		for ; j < len(lines); j++ {
+			line, ok := getDSLLine(texts[j])
+			if !ok {
+				break
+			}
+			dsl = append(dsl, strings.TrimSuffix(line, "\n"))
+		}
+		for len(dsl) > 0 && dsl[len(dsl)-1] == "" { // trailing empty lines
+			dsl = dsl[:len(dsl)-1]
+			j--
+		}
+		if len(dsl) == 0 {
+			continue // nothing to format
+		}
+		block, err := formatDSL(dsl, formatDSLLine)
+		if err != nil {
+			return comment, err
+		}
+		for _, line := range block {
+			result = append(result, strings.TrimRight("//\t"+line, "\t"))
+		}
+		if j < len(lines) && strings.TrimSpace(texts[j]) != "" {
+			result = append(result, "//") // separate the code block from the text
+		}
+		i = j - 1
+	}
+	return strings.Join(result, "\n"+indent), nil
+}
+
+// commentText returns the text of a line comment like
+// ast.CommentGroup.Text does: without the comment marker, the first space
+// and trailing white space.
+// So flows are found exactly like extractFlows finds them.
+func commentText(line string) string {
+	text := strings.TrimPrefix(strings.TrimSpace(line), "//")
+	return strings.TrimRight(strings.TrimPrefix(text, " "), " \t\r")
+}
+
+// formatDSL formats all lines of the DSL and returns them with an empty
+// line in front (code blocks in doc comments need it).
+// The formatted flow has to mean exactly the same as the original one.
+func formatDSL(dsl []string, formatLine func(string) string) ([]string, error) {
+	before, err := parseFlowDSL(strings.Join(dsl, "\n")+"\n", "flow")
+	if err != nil {
+		return nil, fmt.Errorf("the flow can't be parsed: %w", err)
+	}
+	block := make([]string, 1, len(dsl)+1)
+	for _, line := range dsl {
+		line = formatLine(line)
+		if line == "" && block[len(block)-1] == "" {
+			continue // no double empty lines
+		}
+		block = append(block, line)
+	}
+	after, err := parseFlowDSL(strings.Join(block, "\n")+"\n", "flow")
+	if err != nil {
+		return nil, fmt.Errorf("the formatted flow can't be parsed: %w", err)
+	}
+	if flowKey(before) != flowKey(after) {
+		return nil, errors.New("formatting would change the meaning of the flow")
+	}
+	return block, nil
+}
+
+// flowKey describes the meaning of the parsed flow without any source
+// positions. Flows with equal keys are the same.
+func flowKey(flow data.Flow) string {
+	b := strings.Builder{}
+	for _, line := range flow.Parts {
+		for _, part := range line {
+			switch p := part.(type) {
+			case data.Arrow:
+				b.WriteString(portToString(p.FromPort) + " " + dataToString(p.Data) + "-> " +
+					portToString(p.ToPort) + " ")
+			case data.Component:
+				b.WriteString(fmt.Sprintf("[%q %t] ", componentLabel(p), p.Decl.VagueType))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// formatDSLLine gives a line of the flow DSL a canonical spacing:
+// single spaces between parts and around arrows but no spaces inside of
+// brackets and parentheses, after commas a space and data types directly
+// in front of their arrow: `in (T1, T2)-> [comp] -> out`
+func formatDSLLine(line string) string {
+	s := strings.Join(strings.Fields(line), " ")
+	b := make([]byte, 0, len(s)+8)
+	last := func() byte {
+		if len(b) == 0 {
+			return 0
+		}
+		return b[len(b)-1]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ':
+			next := s[i+1] // never the last character
+			if l := last(); l == '[' || l == '(' || l == ' ' ||
+				next == ']' || next == ')' || next == ',' ||
+				(l == ')' && strings.HasPrefix(s[i+1:], "->")) {
+				continue
+			}
+			b = append(b, ' ')
+		case c == ',':
+			b = append(b, ", "...)
+			if i+1 < len(s) && s[i+1] == ' ' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "->"):
+			if l := last(); l != 0 && l != ' ' && l != ')' {
+				b = append(b, ' ')
+			}
+			b = append(b, "->"...)
+			i++
+			if i+1 < len(s) && s[i+1] != ' ' {
+				b = append(b, ' ')
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,189 @@
+package goast_test
+
+import (
+	"go/format"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestFormatSource(t *testing.T) {
+	specs := []struct {
+		name        string
+		givenSrc    string
+		expectedSrc string
+	}{
+		{
+			name: "old-style",
+			givenSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//     in (Tint1)-> [foo1] -> [BlaSome] -> [foo2] -> out
+// Some additional bla, bla.
+func Bla() {}
+`,
+			expectedSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//
+//	in (Tint1)-> [foo1] -> [BlaSome] -> [foo2] -> out
+//
+// Some additional bla, bla.
+func Bla() {}
+`,
+		}, {
+			name: "canonical-spacing",
+			givenSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//     in ( a.T1 ,b )->[foo1]   ->out
+//
+//
+//     [foo1] err ->   err
+func Bla() {}
+`,
+			expectedSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//
+//	in (a.T1, b)-> [foo1] -> out
+//
+//	[foo1] err -> err
+func Bla() {}
+`,
+		}, {
+			name: "indented-comment",
+			givenSrc: `package x
+
+func Bla() {
+	// flow in a function.
+	//
+	// flow:
+	//	in->[foo1]->out
+	foo1()
+}
+`,
+			expectedSrc: `package x
+
+func Bla() {
+	// flow in a function.
+	//
+	// flow:
+	//
+	//	in -> [foo1] -> out
+	foo1()
+}
+`,
+		}, {
+			name: "named-capitalized-marker",
+			givenSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//     in->[foo1]->out
+//
+// Flow: Second
+//     in->[foo2]->out
+//
+// Some additional bla, bla.
+func Bla() {}
+`,
+			expectedSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//
+//	in -> [foo1] -> out
+//
+// Flow: Second
+//
+//	in -> [foo2] -> out
+//
+// Some additional bla, bla.
+func Bla() {}
+`,
+		}, {
+			name: "indented-marker",
+			givenSrc: `package x
+
+func Bla() {
+	// flow:
+	//	in->[foo1]->out
+	//
+	//  flow:
+	//     in->[foo2]->out
+	foo1()
+}
+`,
+			expectedSrc: `package x
+
+func Bla() {
+	// flow:
+	//
+	//	in -> [foo1] -> out
+	//
+	//  flow:
+	//     in->[foo2]->out
+	foo1()
+}
+`,
+		}, {
+			name: "already-formatted",
+			givenSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//
+//	in (Tint1)-> [foo1] -> out
+//
+// Some additional bla, bla.
+func Bla() {}
+`,
+		}, {
+			name: "broken-flow",
+			givenSrc: `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//     in (Tint1)-> [foo1 -> out
+func Bla() {}
+`,
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			if spec.expectedSrc == "" {
+				spec.expectedSrc = spec.givenSrc
+			}
+			actual, err := goast.FormatSource("x.go", []byte(spec.givenSrc))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(actual) != spec.expectedSrc {
+				t.Errorf("Expected source:\n%s\nbut got:\n%s", spec.expectedSrc, actual)
+			}
+			if spec.name == "broken-flow" {
+				return
+			}
+			gofmted, err := format.Source(actual)
+			if err != nil {
+				t.Fatalf("Unable to gofmt source: %v", err)
+			}
+			if string(gofmted) != string(actual) {
+				t.Errorf("Formatted source isn't stable with gofmt:\n%s", gofmted)
+			}
+		})
+	}
+}
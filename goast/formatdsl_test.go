@@ -0,0 +1,26 @@
+package goast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatDSLKeepsMeaning(t *testing.T) {
+	given := []string{"in (int)-> [check] err-> error"}
+
+	actual, err := formatDSL(given, formatDSLLine)
+	if err != nil {
+		t.Fatalf("Unable to format the flow: %v", err)
+	}
+	expected := []string{"", "in (int)-> [check] err -> error"}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the formatted flow %q but got %q", expected, actual)
+	}
+
+	dropPort := func(line string) string { // still parsable but a different flow
+		return strings.Replace(formatDSLLine(line), " err ->", " ->", 1)
+	}
+	if actual, err = formatDSL(given, dropPort); err == nil {
+		t.Errorf("Expected an error because the meaning changes but got the flow %q", actual)
+	}
+}
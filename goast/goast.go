@@ -2,32 +2,43 @@ package goast
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/flowdev/gflowparser"
 	"github.com/flowdev/gflowparser/data"
 )
 
+var (
+	flowMarkerRegex = regexp.MustCompile(`^(?i:flow):(?:[ \t]+([A-Za-z_][A-Za-z0-9_]*))?[ \t]*$`)
+	flowLikeRegex   = regexp.MustCompile(`^[ \t]*(?i:flow)[ \t]*:`)
+)
+
 const (
-	flowMarker           = "\n\nflow:\n"
-	mdStart              = "# Flow Documentation For File: "
-	flowStart            = "\n## Flow: [%s](%s#L%dL%d)\n"
-	dslMarker            = "    "
-	referenceTableHeader = `Components | Data
----------- | -----
-`
+	dslMarker      = "    "
 	goTestFileName = `_test.go`
 	goTestPackName = `_test`
+
+	// packageIndexName is the base name of the package index document that
+	// contains the flows of the package doc.
+	// The go tool ignores files starting with '_', so it doesn't clash with
+	// the documentation of a real Go file.
+	packageIndexName = "_index"
+
+	// packageFlowName is the name of an unnamed flow in the package doc.
+	packageFlowName = "Package"
 )
 
 type sourcePartKind int
@@ -48,12 +59,60 @@ type sourcePart struct {
 	importPath string
 	goFile     string
 	mdFile     *mdFile
+	docStart   string            // only set for flows: doc in front of the DSL
+	dsl        string            // only set for flows
+	docEnd     string            // only set for flows: doc after the DSL
+	flowFile   string            // only set for flows of flow files
+	sig        *funcSig          // only set for functions and flows documenting them
+	def        *typeDef          // only set for types
+	graph      *flowGraph        // only set if the flow is exported or linked
+	graphURLs  map[string]string // source URLs of the graph's components
 }
 
 type mdFile struct {
-	name   string
-	fImps  *fileImps
-	osfile *os.File
+	name    string
+	fImps   *fileImps
+	buf     *bytes.Buffer
+	pkgName string // only set for the package index
+}
+
+// renderer writes the documentation of flows in one output format.
+type renderer interface {
+	fileExt() string
+	startFile(buf *bytes.Buffer, goFile string)
+	startIndex(buf *bytes.Buffer, pkgName string)
+	startFlow(buf *bytes.Buffer, flow *sourcePart, doc string)
+	diagram(buf *bytes.Buffer, flow *sourcePart, dsl string, svg []byte) error
+	references(buf *bytes.Buffer, compLinks, dataLinks []link)
+	portReferences(buf *bytes.Buffer, refs []compRefs)
+	signatures(buf *bytes.Buffer, sigLinks []link)
+	dataTypes(buf *bytes.Buffer, types []typeEntry)
+	endFlow(buf *bytes.Buffer, doc string)
+	flowIndex(buf *bytes.Buffer, flowLinks []link)
+	endFile(buf *bytes.Buffer)
+}
+
+func newRenderer(opts Options) (renderer, error) {
+	switch opts.Embed {
+	case EmbedNone, EmbedInline, EmbedDataURI:
+	default:
+		return nil, fmt.Errorf("unknown SVG embedding mode: %q", opts.Embed)
+	}
+	switch opts.Diagram {
+	case DiagramSVG, "", DiagramMermaid:
+	default:
+		return nil, fmt.Errorf("unknown diagram type: %q", opts.Diagram)
+	}
+	switch opts.Format {
+	case FormatMarkdown, "":
+		return markdownRenderer{embed: opts.Embed, diagramType: opts.Diagram}, nil
+	case FormatHTML:
+		if opts.Diagram == DiagramMermaid {
+			return nil, fmt.Errorf("mermaid diagrams are only supported for Markdown")
+		}
+		return htmlRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %q", opts.Format)
 }
 
 const (
@@ -70,35 +129,162 @@ const (
 type goPackage struct {
 	path    string
 	partMap map[string]*sourcePart
+	loaded  chan struct{} // closed as soon as the partMap is loaded
 }
 
+// packageDict is safe for concurrent use.
 type packageDict struct {
-	packs      map[string]*goPackage
-	srcRoots   []string
-	projRoot   string
-	cwd        string
-	localLinks bool
+	mutex    sync.Mutex
+	packs    map[string]*goPackage
+	outs     map[string]*output // outputs by directory
+	srcRoots []string
+	projRoot string
+	cwd      string
+	opts     Options
+	render   renderer
+	model    *Model        // only set for the model formats (JSON, YAML)
+	overview *overview     // only set if an overview is requested
+	cache    *flowCache    // only set if caching is requested
+	workers  chan struct{} // limits the number of concurrent workers
+	mem      *memFiles     // only set if the files are kept in memory
 }
 
+// Options are the settings that influence the generated documentation.
+type Options struct {
+	LocalLinks   bool     // create links to local files instead of URLs
+	Format       string   // output format: FormatMarkdown or FormatHTML
+	Embed        string   // embedding of SVG diagrams into Markdown: Embed*
+	Diagram      string   // type of the diagrams: DiagramSVG or DiagramMermaid
+	Exports      []string // additional export formats for flows: Export*
+	PackageGraph bool     // export the flows of a package into one graph, too
+	Overview     bool     // write a project-wide flow call graph, too
+	SubflowLinks bool     // link sub-flows in diagrams to their documentation
+	SVGLinks     bool     // link all components and data types in diagrams
+	Signatures   bool     // add a table with the signatures of the components
+	DataTypes    bool     // add the definitions of the data types
+	PortRefs     bool     // list the data types per component port instead of the reference table
+	Cache        bool     // only regenerate changed flows and remove stale files
+	Clean        bool     // remove stale generated files
+	DryRun       bool     // only list the files that would be written or removed
+	Jobs         int      // maximum number of concurrent workers (default: number of CPUs)
+}
+
+// Output formats for the generated documentation.
+const (
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+	FormatYAML     = "yaml"
+)
+
+// Types of diagrams for flows.
+const (
+	DiagramSVG     = "svg"
+	DiagramMermaid = "mermaid"
+)
+
+// Modes for embedding SVG diagrams into Markdown files.
+const (
+	EmbedNone    = ""        // reference separate SVG files
+	EmbedInline  = "inline"  // embed the SVG as inline <svg> element
+	EmbedDataURI = "datauri" // embed the SVG as base64 data URI image
+)
+
 // NewPackageDict creates a new dictionary for packages
-func NewPackageDict(srcRoots []string, projRoot string, localLinks bool) *packageDict {
+func NewPackageDict(srcRoots []string, projRoot string, opts Options) (*packageDict, error) {
+	var r renderer
+	var model *Model
+	var err error
+	if opts.Format == FormatJSON || opts.Format == FormatYAML {
+		if len(opts.Exports) > 0 || opts.Overview {
+			return nil, fmt.Errorf("exports and the overview can't be generated with the %q format", opts.Format)
+		}
+		model = &Model{Version: ModelVersion}
+	} else if r, err = newRenderer(opts); err != nil {
+		return nil, err
+	}
+	for _, export := range opts.Exports {
+		if export != ExportDOT && export != ExportPlantUML {
+			return nil, fmt.Errorf("unknown export format: %q", export)
+		}
+	}
+	var ov *overview
+	if opts.Overview {
+		ov = newOverview()
+	}
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
 	return &packageDict{
-		packs:      make(map[string]*goPackage),
-		srcRoots:   srcRoots,
-		projRoot:   projRoot,
-		localLinks: localLinks,
+		packs:    make(map[string]*goPackage),
+		outs:     make(map[string]*output),
+		srcRoots: srcRoots,
+		projRoot: projRoot,
+		opts:     opts,
+		render:   r,
+		model:    model,
+		overview: ov,
+		workers:  make(chan struct{}, jobs),
+	}, nil
+}
+
+// partMapFor returns the source parts of the package with the given path.
+// The package is loaded only once even if it is requested concurrently.
+func (pd *packageDict) partMapFor(path string, load func() map[string]*sourcePart,
+) map[string]*sourcePart {
+	pd.mutex.Lock()
+	goPack := pd.packs[path]
+	if goPack != nil {
+		pd.mutex.Unlock()
+		<-goPack.loaded
+		return goPack.partMap
 	}
+	goPack = &goPackage{path: path, loaded: make(chan struct{})}
+	pd.packs[path] = goPack
+	pd.mutex.Unlock()
+
+	goPack.partMap = load()
+	close(goPack.loaded)
+	return goPack.partMap
 }
 
-func (pd *packageDict) addPackage(path string, partMap map[string]*sourcePart) {
-	pd.packs[path] = &goPackage{path: path, partMap: partMap}
+// startRun resets the state of a previous run.
+// So a package dictionary can be used for multiple runs (e.g. in watch mode).
+func (pd *packageDict) startRun() {
+	pd.packs = make(map[string]*goPackage)
+	pd.outs = make(map[string]*output)
+	if pd.model != nil {
+		pd.model = &Model{Version: ModelVersion}
+	}
+	if pd.overview != nil {
+		pd.overview = newOverview()
+	}
 }
-func (pd *packageDict) getPartFor(path string, markedName string) *sourcePart {
-	goPack := pd.packs[path]
-	if goPack == nil {
-		return nil
+
+// output returns the output for files in the given directory.
+func (pd *packageDict) output(dir string) *output {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	out := pd.outs[dir]
+	if out == nil {
+		out = newOutput(dir, pd.cwd, pd.opts.DryRun)
+		out.mem = pd.mem
+		pd.outs[dir] = out
 	}
-	return goPack.partMap[markedName]
+	return out
+}
+
+// writeFile writes a generated file (given with absolute path).
+func (pd *packageDict) writeFile(name string, content []byte) error {
+	return pd.output(filepath.Dir(name)).writeFile(name, content)
+}
+
+// work runs the function as soon as a worker is available.
+func (pd *packageDict) work(f func()) {
+	pd.workers <- struct{}{}
+	defer func() { <-pd.workers }()
+	f()
 }
 
 //
@@ -116,34 +302,42 @@ func newFileImps(
 	packDict *packageDict,
 	fset *token.FileSet,
 ) *fileImps {
-	imps := make(map[string]string)
+	return &fileImps{imps: importMap(astImps), packDict: packDict, fset: fset}
+}
+
+// importMap maps the local names of the imported packages to their paths.
+func importMap(astImps []*ast.ImportSpec) map[string]string {
+	imps := make(map[string]string, len(astImps))
 	for _, astImp := range astImps {
-		key := ""
-		val := strings.Trim(astImp.Path.Value, "\"")
-		if astImp.Name == nil {
-			key = strings.Trim(path.Base(val), "\"")
-		} else {
-			key = strings.TrimRight(astImp.Name.Name, ".")
-		}
-		if key != "_" && key != "" && key != "." && key != "/" { // ignore funny imports
-			imps[key] = val
+		if key := importName(astImp); key != "" {
+			imps[key] = strings.Trim(astImp.Path.Value, "\"")
 		}
 	}
-	return &fileImps{imps: imps, packDict: packDict, fset: fset}
+	return imps
+}
+
+// importName returns the local name of the imported package or the empty
+// string for funny imports.
+func importName(astImp *ast.ImportSpec) string {
+	key := ""
+	if astImp.Name == nil {
+		key = path.Base(strings.Trim(astImp.Path.Value, "\""))
+	} else {
+		key = strings.TrimRight(astImp.Name.Name, ".")
+	}
+	if key == "_" || key == "." || key == "/" {
+		return ""
+	}
+	return key
 }
 func (fi *fileImps) getPartFor(pack string, markedName string) *sourcePart {
 	path := fi.imps[pack]
 	if path == "" {
 		return nil
 	}
-	part := fi.packDict.getPartFor(path, markedName)
-	if part != nil {
-		return part
-	}
-	partMap := fi.findPartsForPath(path)
-	if partMap != nil {
-		fi.packDict.addPackage(path, partMap)
-	}
+	partMap := fi.packDict.partMapFor(path, func() map[string]*sourcePart {
+		return fi.findPartsForPath(path)
+	})
 	return partMap[markedName]
 }
 func (fi *fileImps) findPartsForPath(path string) map[string]*sourcePart {
@@ -161,18 +355,23 @@ func (fi *fileImps) findPartsForPath(path string) map[string]*sourcePart {
 			}
 		}
 	}
-	pkgs, err := parser.ParseDir(fi.fset, dir, excludeTests, parser.ParseComments)
+	var pkgs map[string]*ast.Package
+	var err error
+	fi.packDict.work(func() {
+		pkgs, err = parser.ParseDir(fi.fset, dir, excludeTests, parser.ParseComments)
+	})
 	if err != nil {
 		log.Printf("ERROR: Unable to parse additional directory '%s': %v", dir, err)
 		return nil
 	}
 	partMap := make(map[string]*sourcePart)
 	flows := make([]*sourcePart, 0, 128)
-	for _, pkg := range pkgs { // iterate over subpackages (e.g.: xxx and xxx_test)
+	for _, pkg := range sortedPackages(pkgs) { // iterate over subpackages (e.g.: xxx and xxx_test)
 		if isTestPackage(pkg.Name) {
 			continue
 		}
-		for name, astf := range pkg.Files {
+		for _, name := range sortedFileNames(pkg.Files) {
+			astf := pkg.Files[name]
 			if flows, err = findSourceParts(
 				partMap, flows,
 				astf,
@@ -184,9 +383,35 @@ func (fi *fileImps) findPartsForPath(path string) map[string]*sourcePart {
 			}
 		}
 	}
+	if _, err = findFlowFiles(partMap, flows, dir, path); err != nil {
+		log.Printf("ERROR: Unable to find all flow files in directory '%s': %v", dir, err)
+	}
 	return partMap
 }
 
+// sortedPackages returns the packages sorted by name.
+func sortedPackages(pkgs map[string]*ast.Package) []*ast.Package {
+	result := make([]*ast.Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		result = append(result, pkg)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// sortedFileNames returns the names of the files sorted.
+// So the flows of a package are found in source order.
+func sortedFileNames(files map[string]*ast.File) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func isTestPackage(name string) bool {
 	if len(name) >= len(goTestPackName) && name[len(name)-len(goTestPackName):] == goTestPackName {
 		return true
@@ -207,22 +432,97 @@ func excludeTests(fi os.FileInfo) bool {
 
 // ProcessDir processes the whole given directory
 func ProcessDir(dir string, packDict *packageDict) error {
-	cwd, err := filepath.Abs(dir)
+	return ProcessDirs([]string{dir}, packDict)
+}
+
+// ProcessDirs processes all the given directories concurrently.
+// The documentation of each package is written into its own directory and
+// project-wide documents are written into the current working directory.
+func ProcessDirs(dirs []string, packDict *packageDict) error {
+	cwd, err := filepath.Abs(".")
 	if err != nil {
-		return fmt.Errorf("unable to get working directory '%s': %w", dir, err)
+		return fmt.Errorf("unable to get working directory: %w", err)
 	}
 	packDict.cwd = cwd
-	fset := token.NewFileSet() // needed for any kind of parsing
+	packDict.startRun()
+	if packDict.cache != nil {
+		packDict.cache.next()
+	} else if packDict.opts.Cache {
+		packDict.cache = loadCache(filepath.Join(cwd, cacheFileName), packDict.opts)
+	}
+	fset := token.NewFileSet() // needed for any kind of parsing; safe for concurrent use
+
+	errs := make([]error, len(dirs))
+	wg := sync.WaitGroup{}
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			errs[i] = processDir(dir, fset, packDict)
+		}(i, dir)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	if packDict.model != nil {
+		sortModel(packDict.model)
+		if err = writeModel(packDict.model, packDict.opts.Format, packDict); err != nil {
+			return err
+		}
+	}
+	if packDict.overview != nil {
+		if err = writeOverview(packDict.overview, packDict.opts.Diagram, packDict); err != nil {
+			return err
+		}
+	}
+	if packDict.cache != nil && !packDict.opts.DryRun && packDict.mem == nil {
+		if err = packDict.cache.save(); err != nil {
+			return err
+		}
+	}
+	return packDict.finishOutputs()
+}
+
+// processDir processes the packages of a single directory.
+func processDir(dir string, fset *token.FileSet, packDict *packageDict) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("unable to get absolute directory for '%s': %w", dir, err)
+	}
+	packDict.output(absDir) // remember the directory even if nothing is written
 	fmt.Println("Parsing the whole directory:", dir)
-	pkgs, err := parser.ParseDir(fset, dir, excludeTests, parser.ParseComments)
+	var pkgs map[string]*ast.Package
+	packDict.work(func() {
+		pkgs, err = parser.ParseDir(fset, absDir, excludeTests, parser.ParseComments)
+	})
 	if err != nil {
 		return fmt.Errorf("unable to parse the directory '%s': %w", dir, err)
 	}
-	for _, pkg := range pkgs { // iterate over subpackages (e.g.: xxx and xxx_test)
+	for _, pkg := range sortedPackages(pkgs) { // iterate over subpackages (e.g.: xxx and xxx_test)
 		if isTestPackage(pkg.Name) {
 			continue
 		}
-		if err := processPackage(pkg, fset, packDict); err != nil {
+		if err := processPackage(pkg, absDir, fset, packDict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishOutputs removes stale files if requested and writes the manifests
+// of all directories.
+func (pd *packageDict) finishOutputs() error {
+	dirs := make([]string, 0, len(pd.outs))
+	for dir := range pd.outs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := pd.outs[dir].finish(pd.opts.Clean || pd.opts.Cache); err != nil {
 			return err
 		}
 	}
@@ -230,17 +530,22 @@ func ProcessDir(dir string, packDict *packageDict) error {
 }
 
 // processPackage is processing all the files of one Go package.
-func processPackage(pkg *ast.Package, fset *token.FileSet, packDict *packageDict) error {
+func processPackage(pkg *ast.Package, dir string, fset *token.FileSet, packDict *packageDict) error {
 	fmt.Println("processing package:", pkg.Name)
 	partMap := make(map[string]*sourcePart)
 	flows := make([]*sourcePart, 0, 128)
 	fileMap := make(map[string]*mdFile)
 	var err error
 
-	for name, astf := range pkg.Files {
+	fileNames := sortedFileNames(pkg.Files)
+	indexName := filepath.Join(dir, packageIndexName)
+	var indexImps *fileImps
+	for _, name := range fileNames {
+		astf := pkg.Files[name]
 		fImps := newFileImps(astf.Imports, packDict, fset)
 		baseName := goNameToBase(name)
 		fileMap[baseName] = &mdFile{name: baseName, fImps: fImps}
+		n := len(flows)
 		if flows, err = findSourceParts(
 			partMap, flows,
 			astf,
@@ -249,22 +554,77 @@ func processPackage(pkg *ast.Package, fset *token.FileSet, packDict *packageDict
 			return fmt.Errorf(
 				"unable to find all flows in package (%s): %w", pkg.Name, err)
 		}
+		if len(flows) > n && flows[n].mdFile.name == indexName && indexImps == nil {
+			indexImps = fImps
+		}
+	}
+	if flows, err = findFlowFiles(partMap, flows, dir, ""); err != nil {
+		return fmt.Errorf(
+			"unable to find all flow files of package (%s): %w", pkg.Name, err)
+	}
+	if indexImps != nil {
+		if fileMap[indexName] != nil {
+			return fmt.Errorf("the package index of package (%s) clashes with the documentation of '%s.go'",
+				pkg.Name, indexName)
+		}
+		fileMap[indexName] = &mdFile{name: indexName, fImps: indexImps, pkgName: pkg.Name}
 	}
-	fmt.Println("Found", len(flows), "flows.")
 	for _, f := range flows {
+		if f.mdFile.name == indexName && f.flowFile != "" {
+			return fmt.Errorf("the flow file '%s' clashes with the package index of package (%s)",
+				f.flowFile, pkg.Name)
+		}
+		if f.mdFile.name == indexName && partMap[markerFunc+f.name] != nil {
+			return fmt.Errorf("the package flow '%s' of package (%s) clashes with the function of "+
+				"the same name, please name it: 'flow: <name>'", f.name, pkg.Name)
+		}
+		if f.flowFile == "" || f.goFile != f.flowFile { // not a flow file without Go function
+			continue
+		}
+		if fileMap[f.mdFile.name] != nil {
+			return fmt.Errorf("the flow file '%s' clashes with the documentation of '%s.go'",
+				f.flowFile, f.mdFile.name)
+		}
+		fileMap[f.mdFile.name] = &mdFile{name: f.mdFile.name, fImps: newFileImps(nil, packDict, fset)}
+		fileNames = append(fileNames, f.goFile)
+	}
+	fmt.Println("Found", len(flows), "flows.")
+	convs := convertFlows(flows, packDict)
+	if packDict.model != nil {
+		if err = addToModel(packDict.model, pkg.Name, dir, flows, convs, fileMap, partMap, packDict); err != nil {
+			return fmt.Errorf(
+				"unable to add all flows of package (%s) to the model: %w", pkg.Name, err)
+		}
+		return nil
+	}
+	for i, f := range flows {
 		if err = startFlowFile(f, fileMap); err != nil {
 			return fmt.Errorf(
 				"unable to start all Markdown files in package (%s): %w",
 				pkg.Name, err)
 		}
-		if err = addToMDFile(f, partMap); err != nil {
+		if err = addToMDFile(f, convs[i], partMap); err != nil {
 			return fmt.Errorf(
 				"unable to process all flows in package (%s): %w", pkg.Name, err)
 		}
 	}
+	if index := fileMap[indexName]; index != nil && index.buf != nil {
+		addFlowIndex(index, flows, packDict.render)
+	}
 	fmt.Println("processed flows with ", len(partMap), "souce parts.")
-	for _, f := range fileMap {
-		if err = endMDFile(f); err != nil {
+	if packDict.opts.PackageGraph && len(packDict.opts.Exports) > 0 {
+		if err = exportPackage(pkg.Name, dir, flows, packDict); err != nil {
+			return fmt.Errorf(
+				"unable to export the flows of package (%s): %w", pkg.Name, err)
+		}
+	}
+	ended := make(map[string]bool, len(fileMap))
+	for _, name := range append(fileNames, indexName) {
+		if name = goNameToBase(name); ended[name] {
+			continue
+		}
+		ended[name] = true
+		if err = endMDFile(fileMap[name], packDict.render); err != nil {
 			log.Printf("Error while ending file: %v", err)
 		}
 	}
@@ -282,56 +642,144 @@ func findSourceParts(
 	goname string, path string, fset *token.FileSet,
 ) ([]*sourcePart, error) {
 	baseName := goNameToBase(goname)
+	imps := importMap(astf.Imports) // for the types of the file
+	var err error
+	// Port functions and methods of different types may document flows with
+	// the same name. Only package flows have to be unique.
+	addFlows := func(cg *ast.CommentGroup, name string, node ast.Node, mdName string) []*sourcePart {
+		parts := flowParts(cg, name, node, goname, path, mdName, fset)
+		for _, flow := range parts {
+			if old := partMap[markerFlow+flow.name]; old != nil {
+				msg := fmt.Sprintf("flow '%s' is defined twice: in '%s' line %d and in '%s' line %d",
+					flow.name, old.goFile, old.start, flow.goFile, flow.start)
+				if node == astf.Name && err == nil {
+					err = errors.New(msg)
+				} else if path == "" {
+					log.Printf("WARNING: %s", msg)
+				}
+			}
+			partMap[markerFlow+flow.name] = flow
+			flows = append(flows, flow)
+		}
+		return parts
+	}
 
+	if astf.Doc != nil { // package level flows
+		addFlows(astf.Doc, packageFlowName, astf.Name,
+			filepath.Join(filepath.Dir(goname), packageIndexName))
+	}
 	for _, idecl := range astf.Decls {
 		switch decl := idecl.(type) {
 		case *ast.FuncDecl:
-			doc := decl.Doc.Text()
 			name := decl.Name.Name
-			if strings.Contains(doc, flowMarker) {
-				if i := strings.Index(name, "_"); i >= 0 {
-					name = name[:i] // cut off the port name
-				}
-				flow := &sourcePart{
-					kind:       sourcePartFlow,
-					name:       name,
-					doc:        doc,
-					start:      lineFor(decl.Pos(), fset),
-					end:        lineFor(decl.End(), fset),
-					importPath: path,
-					goFile:     goname,
-					mdFile:     &mdFile{name: baseName},
+			if i := strings.Index(name, "_"); i >= 0 {
+				name = name[:i] // cut off the port name
+			}
+			sig := newFuncSig(decl)
+			if parts := addFlows(decl.Doc, name, decl, baseName); len(parts) > 0 {
+				for _, flow := range parts {
+					flow.sig = sig
 				}
-				partMap[markerFlow+name] = flow
-				flows = append(flows, flow)
 			} else {
 				partMap[markerFunc+decl.Name.Name] = &sourcePart{
 					kind:       sourcePartFunc,
-					name:       name,
+					name:       decl.Name.Name,
+					doc:        decl.Doc.Text(),
 					start:      lineFor(decl.Pos(), fset),
 					end:        lineFor(decl.End(), fset),
 					importPath: path,
 					goFile:     goname,
+					sig:        sig,
 				}
 			}
 		case *ast.GenDecl:
-			if decl.Tok == token.TYPE {
-				for _, s := range decl.Specs {
-					ts := s.(*ast.TypeSpec)
-					name := ts.Name.Name
+			for _, s := range decl.Specs {
+				switch spec := s.(type) {
+				case *ast.TypeSpec:
+					name := spec.Name.Name
+					addFlows(specDoc(spec.Doc, decl), name, spec, baseName)
 					partMap[markerType+name] = &sourcePart{
 						kind:       sourcePartType,
 						name:       name,
-						start:      lineFor(ts.Pos(), fset),
-						end:        lineFor(ts.End(), fset),
+						start:      lineFor(spec.Pos(), fset),
+						end:        lineFor(spec.End(), fset),
 						importPath: path,
 						goFile:     goname,
+						def:        newTypeDef(spec, specDoc(spec.Doc, decl), imps),
+					}
+				case *ast.ValueSpec:
+					if decl.Tok == token.VAR {
+						addFlows(specDoc(spec.Doc, decl), spec.Names[0].Name, spec, baseName)
 					}
 				}
 			}
 		}
 	}
-	return flows, nil
+	return flows, err
+}
+
+// specDoc returns the doc of the spec or the doc of the declaration if it
+// is the only spec in it.
+func specDoc(doc *ast.CommentGroup, decl *ast.GenDecl) *ast.CommentGroup {
+	if doc == nil && len(decl.Specs) == 1 {
+		return decl.Doc
+	}
+	return doc
+}
+
+// flowParts creates a flow part for every flow in the comment.
+// Unnamed flows get the name of the documented node.
+func flowParts(
+	cg *ast.CommentGroup, name string, node ast.Node,
+	goname string, path string, mdName string, fset *token.FileSet,
+) []*sourcePart {
+	blocks := findFlowBlocks(cg, path, fset)
+	parts := make([]*sourcePart, len(blocks))
+	for i, b := range blocks {
+		flowName := b.name
+		if flowName == "" {
+			flowName = name
+			if i > 0 {
+				flowName += strconv.Itoa(i + 1)
+			}
+		}
+		parts[i] = &sourcePart{
+			kind:       sourcePartFlow,
+			name:       flowName,
+			doc:        cg.Text(),
+			start:      lineFor(node.Pos(), fset),
+			end:        lineFor(node.End(), fset),
+			importPath: path,
+			goFile:     goname,
+			mdFile:     &mdFile{name: mdName},
+			docStart:   b.start,
+			dsl:        b.dsl,
+			docEnd:     b.end,
+		}
+	}
+	return parts
+}
+
+// findFlowBlocks finds all usable flow blocks in the comment.
+// Warnings are only logged for the processed packages (path is empty).
+func findFlowBlocks(cg *ast.CommentGroup, path string, fset *token.FileSet) []flowBlock {
+	if cg == nil {
+		return nil
+	}
+	blocks, warnings := extractFlows(cg.Text())
+	if path == "" {
+		pos := fset.Position(cg.Pos())
+		for _, w := range warnings {
+			log.Printf("WARNING: %s:%d: %s", pos.Filename, pos.Line+w.line, w.msg)
+		}
+	}
+	result := blocks[:0]
+	for _, b := range blocks {
+		if b.dsl != "" {
+			result = append(result, b)
+		}
+	}
+	return result
 }
 func goNameToBase(goname string) string {
 	ext := filepath.Ext(goname)
@@ -347,7 +795,7 @@ func lineFor(p token.Pos, fset *token.FileSet) int {
 }
 
 //
-// Write to Markdown file
+// Write to documentation file
 //
 
 func startFlowFile(flow *sourcePart, fileMap map[string]*mdFile) error {
@@ -355,108 +803,103 @@ func startFlowFile(flow *sourcePart, fileMap map[string]*mdFile) error {
 	if file == nil {
 		return fmt.Errorf("missing flow file: " + flow.mdFile.name)
 	}
-	if file.osfile == nil {
-		osfile, err := startMDFile(flow.mdFile.name)
-		if err != nil {
-			return err
+	if file.buf == nil {
+		r := file.fImps.packDict.render
+		if file.pkgName != "" {
+			file.buf = &bytes.Buffer{}
+			r.startIndex(file.buf, file.pkgName)
+		} else {
+			file.buf = startMDFile(flow.goFile, r)
 		}
-		file.osfile = osfile
 	}
 	flow.mdFile = file
 	return nil
 }
 
-func startMDFile(fileBaseName string) (*os.File, error) {
-	mdname := fileBaseName + ".md"
-
-	f, err := os.Create(mdname)
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err = f.WriteString(mdStart + fileBaseName + ".go\n\n"); err != nil {
-		return nil, err
-	}
-
-	return f, nil
+func startMDFile(srcFile string, r renderer) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	r.startFile(buf, filepath.Base(srcFile))
+	return buf
 }
 
-func addToMDFile(f *sourcePart, partMap map[string]*sourcePart) error {
+func addToMDFile(f *sourcePart, conv *convertedFlow, partMap map[string]*sourcePart) error {
 	fmt.Println("processing flow:", f.name)
-	if _, err := f.mdFile.osfile.WriteString(
-		fmt.Sprintf(flowStart, f.name, f.goFile, f.start, f.end)); err != nil {
-
-		return err
+	r := f.mdFile.fImps.packDict.render
+	start, flow, end := f.docStart, f.dsl, f.docEnd
+	r.startFlow(f.mdFile.buf, f, start)
+	if conv.err != nil {
+		return conv.err
 	}
-	start, flow, end := ExtractFlowDSL(f.doc)
-	if _, err := f.mdFile.osfile.WriteString(start + "\n"); err != nil {
-		return err
+	svg, compTypes, dataTypes := conv.svg, conv.compTypes, conv.dataTypes
+	var err error
+	if ov := f.mdFile.fImps.packDict.overview; ov != nil {
+		ov.addFlow(f, compTypes, partMap)
 	}
-	log.Printf("Converting FlowDSL: '%s'\n", flow)
-	svg, compTypes, dataTypes, info, err := gflowparser.ConvertFlowDSLToSVG(flow, f.name)
-	if err != nil {
-		return err
+	compLinks, dataLinks := getReferences(f, compTypes, dataTypes, partMap)
+	opts := f.mdFile.fImps.packDict.opts
+	if len(opts.Exports) > 0 || opts.SubflowLinks || opts.SVGLinks || opts.PortRefs {
+		pFlow, err := parseFlowDSL(flow, f.name)
+		if err != nil {
+			return err
+		}
+		f.graph = newFlowGraph(pFlow)
 	}
-	if info != "" {
-		log.Printf("INFO: %s", info)
+	if opts.SVGLinks {
+		svg = linkSVG(svg, componentLinks(f, f.graph, partMap, false), typeLinks(dataLinks))
+	} else if opts.SubflowLinks {
+		svg = linkSVG(svg, componentLinks(f, f.graph, partMap, true), nil)
 	}
-	if err = ioutil.WriteFile(f.name+".svg", svg, os.FileMode(0666)); err != nil {
+	if err = r.diagram(f.mdFile.buf, f, flow, svg); err != nil {
 		return err
 	}
-	if _, err = f.mdFile.osfile.WriteString(
-		fmt.Sprintf("![Flow: %s](./%s.svg)\n\n", f.name, f.name)); err != nil {
-
-		return err
+	if opts.PortRefs {
+		if refs := portReferences(f, f.graph, partMap); len(refs) > 0 {
+			r.portReferences(f.mdFile.buf, refs)
+		}
+	} else if len(compLinks) > 0 || len(dataLinks) > 0 {
+		r.references(f.mdFile.buf, compLinks, dataLinks)
 	}
-	if err = writeReferences(f, compTypes, dataTypes, partMap); err != nil {
-		return err
+	if sigLinks := signatureLinks(compLinks); opts.Signatures && len(sigLinks) > 0 {
+		r.signatures(f.mdFile.buf, sigLinks)
 	}
-	if _, err = f.mdFile.osfile.WriteString(end); err != nil {
-		return err
+	if opts.DataTypes && len(dataLinks) > 0 {
+		r.dataTypes(f.mdFile.buf, typeEntries(dataLinks, partMap, f.mdFile))
 	}
+	r.endFlow(f.mdFile.buf, end)
 
+	if len(opts.Exports) > 0 {
+		f.graphURLs = sourceURLsFor(f.graph, partMap, f.mdFile)
+		if err = exportFlow(f); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func writeReferences(
+// link is a named reference to a flow, function or type.
+// The URL is empty and the part is nil if the target is unknown.
+type link struct {
+	name string
+	url  string
+	part *sourcePart
+}
+
+func getReferences(
 	f *sourcePart, compTypes []data.Type,
 	dataTypes []data.Type,
 	partMap map[string]*sourcePart,
-) error {
+) (compLinks []link, dataLinks []link) {
 	dataTypes = filterTypes(dataTypes)
 	dataTypes = sortTypes(dataTypes)
 	compTypes = sortTypes(compTypes)
-	dataLinks := getLinksForTypes(dataTypes, partMap, f.mdFile)
-
-	n := max(len(compTypes), len(dataLinks))
-	if n == 0 {
-		return nil
+	compLinks = make([]link, len(compTypes))
+	for i, comp := range compTypes {
+		compLinks[i] = getLinkForComponent(comp, partMap, f.mdFile)
 	}
-
-	if _, err := f.mdFile.osfile.WriteString(referenceTableHeader); err != nil {
-		return err
-	}
-	for i := 0; i < n; i++ {
-		row := bytes.Buffer{}
-		if i < len(compTypes) {
-			addComponentToRow(&row, compTypes[i], partMap, f.mdFile)
-		}
-		row.WriteString(" | ")
-		if i < len(dataLinks) {
-			addTypeToRow(&row, dataLinks[i])
-		}
-		row.WriteRune('\n')
-		if _, err := f.mdFile.osfile.Write(row.Bytes()); err != nil {
-			return err
-		}
-	}
-	if _, err := f.mdFile.osfile.WriteString("\n"); err != nil {
-		return err
-	}
-	return nil
+	return compLinks, getLinksForTypes(dataTypes, partMap, f.mdFile)
 }
 func sortTypes(types []data.Type) []data.Type {
-	sort.Slice(types, func(i, j int) bool {
+	sort.SliceStable(types, func(i, j int) bool {
 		if types[i].Package == types[j].Package {
 			return types[i].LocalType < types[j].LocalType
 		}
@@ -490,12 +933,12 @@ func filterTypes(types []data.Type) []data.Type {
 	}
 	return result
 }
-func getLinksForTypes(types []data.Type, partMap map[string]*sourcePart, mdFile *mdFile) []string {
-	links := make([]string, 0, len(types))
+func getLinksForTypes(types []data.Type, partMap map[string]*sourcePart, mdFile *mdFile) []link {
+	links := make([]link, 0, len(types))
 	for _, typ := range types {
-		link := getLinkForType(typ, partMap, mdFile)
-		if link != "" {
-			links = append(links, link)
+		l := getLinkForType(typ, partMap, mdFile)
+		if l.url != "" {
+			links = append(links, l)
 		}
 	}
 	return links
@@ -506,7 +949,7 @@ func typeToString(t data.Type) string {
 	}
 	return t.LocalType
 }
-func addComponentToRow(row *bytes.Buffer, comp data.Type, partMap map[string]*sourcePart, mdFile *mdFile) {
+func getLinkForComponent(comp data.Type, partMap map[string]*sourcePart, mdFile *mdFile) link {
 	var flow, fun *sourcePart
 	cNam := typeToString(comp)
 
@@ -521,32 +964,20 @@ func addComponentToRow(row *bytes.Buffer, comp data.Type, partMap map[string]*so
 		fileName, err := fileNameFor(flow, markerFlow, mdFile)
 		if err != nil {
 			fmt.Println("WARNING: Unable to compute correct URL for flow", cNam, ":", err)
-			fileName = flow.mdFile.name + ".md"
-		}
-		// [link to Google!](http://google.com)
-		row.WriteString(
-			"[" + cNam + "](" +
-				fileName + "#flow-" +
-				strings.ToLower(flow.name) +
-				")")
+			fileName = flow.mdFile.name + mdFile.fImps.packDict.render.fileExt()
+		}
+		return link{name: cNam, url: fileName + "#flow-" + strings.ToLower(flow.name), part: flow}
 	} else if fun != nil {
 		fileName, err := fileNameFor(fun, markerFunc, mdFile)
 		if err != nil {
 			fmt.Println("WARNING: Unable to compute correct URL for function", cNam, ":", err)
 			fileName = fun.goFile
 		}
-		row.WriteString(fmt.Sprintf(
-			"[%s](%s#L%dL%d)",
-			cNam, fileName, fun.start, fun.end,
-		))
-	} else {
-		row.WriteString(cNam)
+		return link{name: cNam, url: fmt.Sprintf("%s#L%dL%d", fileName, fun.start, fun.end), part: fun}
 	}
+	return link{name: cNam}
 }
-func addTypeToRow(row *bytes.Buffer, tNam string) {
-	row.WriteString(tNam)
-}
-func getLinkForType(typ data.Type, partMap map[string]*sourcePart, mdFile *mdFile) string {
+func getLinkForType(typ data.Type, partMap map[string]*sourcePart, mdFile *mdFile) link {
 	var ty *sourcePart
 	tNam := typeToString(typ)
 	if typ.Package == "" {
@@ -554,8 +985,11 @@ func getLinkForType(typ data.Type, partMap map[string]*sourcePart, mdFile *mdFil
 	} else {
 		ty = mdFile.fImps.getPartFor(typ.Package, markerType+typ.LocalType)
 	}
+	return linkForTypePart(tNam, ty, mdFile)
+}
+func linkForTypePart(tNam string, ty *sourcePart, mdFile *mdFile) link {
 	if ty == nil {
-		return ""
+		return link{name: tNam}
 	}
 
 	fileName, err := fileNameFor(ty, markerType, mdFile)
@@ -563,17 +997,15 @@ func getLinkForType(typ data.Type, partMap map[string]*sourcePart, mdFile *mdFil
 		fmt.Println("WARNING: Unable to compute correct URL for type", tNam, ":", err)
 		fileName = ty.goFile
 	}
-	return fmt.Sprintf(
-		"[%s](%s#L%dL%d)",
-		tNam, fileName, ty.start, ty.end,
-	)
+	return link{name: tNam, url: fmt.Sprintf("%s#L%dL%d", fileName, ty.start, ty.end), part: ty}
 }
 func fileNameFor(part *sourcePart, marker string, mdFile *mdFile) (string, error) {
 	if marker == markerFlow {
 		if mdFile.name == part.mdFile.name { // same MD file
 			return "", nil
 		}
-		return outsideFileNameFor(part.mdFile.name+".md", part, mdFile)
+		return outsideFileNameFor(
+			part.mdFile.name+mdFile.fImps.packDict.render.fileExt(), part, mdFile)
 	}
 
 	return outsideFileNameFor(part.goFile, part, mdFile)
@@ -588,10 +1020,12 @@ func outsideFileNameFor(name string, part *sourcePart, mdFile *mdFile) (string,
 		return "", err
 	}
 	if !strings.HasPrefix(relF, ".."+string(filepath.Separator)) {
-		return filepath.Rel(mdFile.fImps.packDict.cwd, absF) // inside of project always use relative paths
+		// inside of project always use paths relative to the documentation file:
+		rel, err := filepath.Rel(filepath.Dir(mdFile.name), absF)
+		return filepath.ToSlash(rel), err
 	}
 	// outside of project:
-	if mdFile.fImps.packDict.localLinks {
+	if mdFile.fImps.packDict.opts.LocalLinks {
 		return absF, nil
 	}
 	_, lastF := filepath.Split(absF)
@@ -603,66 +1037,140 @@ func outsideFileNameFor(name string, part *sourcePart, mdFile *mdFile) (string,
 	return url + "/" + lastF, nil
 }
 
-// ExtractFlowDSL extracts the flow DSL from a documentation comment string.
+// ExtractFlowDSL extracts the first flow DSL from a documentation comment
+// string.
 // The doc string should be given without comment characters.
 // This function returns everything before the flow in start,
 // the flow DSL itself and everything after it in end.
 func ExtractFlowDSL(doc string) (start, flow, end string) {
-	i := strings.Index(doc, flowMarker)
-	if i < 0 {
+	blocks, _ := extractFlows(doc)
+	if len(blocks) == 0 {
 		return doc, "", ""
 	}
-	start = doc[:i+1]
-	i += len(flowMarker)
+	return blocks[0].start, blocks[0].dsl, blocks[0].rest
+}
 
-	buf := bytes.Buffer{}
-	for dsl, ok := getDSLLine(doc, &i); ok; dsl, ok = getDSLLine(doc, &i) {
-		buf.WriteString(dsl)
-	}
+// flowBlock is a single flow in a documentation comment.
+type flowBlock struct {
+	name  string // optional name after the flow marker
+	line  int    // line of the flow marker in the doc (zero based)
+	start string // text between the last flow block (or start of doc) and this one
+	dsl   string
+	end   string // text after the last flow block (empty for others)
+	rest  string // text after this flow block
+}
 
-	end = doc[i:]
-	if end != "" && end[len(end)-1:] != "\n" {
-		end += "\n"
-	}
-	return start, buf.String(), end
+// flowWarning reports something in a doc comment that looks like a flow
+// but can't be used.
+type flowWarning struct {
+	line int // zero based line in the doc
+	msg  string
 }
-func getDSLLine(doc string, pi *int) (string, bool) {
-	if *pi >= len(doc) {
-		return "", false
+
+// extractFlows finds all flow blocks in a documentation comment string.
+// A flow block starts with a paragraph that consists of the flow marker
+// 'flow:' (in any case and optionally followed by a name).
+// The marker is followed by the DSL lines that have to be indented by four
+// spaces or a tab (like gofmt formats code blocks in doc comments).
+// Empty lines are allowed in the DSL.
+func extractFlows(doc string) ([]flowBlock, []flowWarning) {
+	lines := strings.SplitAfter(doc, "\n")
+	blocks := make([]flowBlock, 0, 2)
+	warnings := make([]flowWarning, 0, 2)
+	textStart := 0
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		m := flowMarkerRegex.FindStringSubmatch(line)
+		if m == nil || (i > 0 && strings.TrimSpace(lines[i-1]) != "") {
+			if flowLikeRegex.MatchString(line) {
+				warnings = append(warnings, flowWarning{line: i, msg: fmt.Sprintf(
+					"%q looks like a flow marker but isn't one (it has to be a paragraph of its own, "+
+						"optionally followed by a name)", strings.TrimSpace(line))})
+			}
+			continue
+		}
+		j := i + 1
+		dsl := make([]string, 0, 8)
+		for ; j < len(lines); j++ {
+			if dslLine, ok := getDSLLine(lines[j]); ok {
+				dsl = append(dsl, dslLine)
+			} else {
+				break
+			}
+		}
+		for len(dsl) > 0 && dsl[0] == "\n" { // gofmt separates code blocks by an empty line
+			dsl = dsl[1:]
+		}
+		for len(dsl) > 0 && dsl[len(dsl)-1] == "\n" {
+			dsl = dsl[:len(dsl)-1]
+		}
+		if len(dsl) == 0 {
+			warnings = append(warnings, flowWarning{line: i, msg: fmt.Sprintf(
+				"flow marker %q isn't followed by DSL lines (indented by four spaces or a tab)",
+				strings.TrimSpace(line))})
+		}
+		blocks = append(blocks, flowBlock{
+			name:  m[1],
+			line:  i,
+			start: joinDocLines(lines[textStart:i]),
+			dsl:   strings.Join(dsl, ""),
+			rest:  joinDocLines(lines[j:]),
+		})
+		textStart = j
+		i = j - 1
 	}
-	tail := doc[*pi:]
-	n := strings.IndexRune(tail, '\n')
-	line := ""
-	if n >= 0 {
-		n++ // include NL
-		line = tail[:n]
-	} else {
-		n = len(tail)
-		line = tail + "\n" // add missing NL
+	if len(blocks) > 0 {
+		blocks[len(blocks)-1].end = blocks[len(blocks)-1].rest
 	}
-
-	dslN := len(dslMarker)
+	return blocks, warnings
+}
+func getDSLLine(line string) (string, bool) {
 	if strings.TrimSpace(line) == "" { // support empty lines
-		*pi += n
 		return "\n", true
 	}
-	if n > dslN && line[:dslN] == dslMarker { // real DSL
-		*pi += n
-		return line[dslN:], true
+	line = strings.TrimRight(line, "\r\n") + "\n"
+	if strings.HasPrefix(line, dslMarker) { // real DSL
+		return line[len(dslMarker):], true
+	}
+	if line[0] == '\t' { // DSL formatted by gofmt
+		return line[1:], true
 	}
 	return "", false
 }
 
-func endMDFile(f *mdFile) error {
-	if f == nil || f.osfile == nil {
-		return nil
+// joinDocLines joins the lines without trailing empty lines but with a
+// final new line.
+func joinDocLines(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
 	}
-	return f.osfile.Close()
+	text := strings.Join(lines, "")
+	if text != "" && text[len(text)-1] != '\n' {
+		text += "\n"
+	}
+	return text
 }
 
-func max(a, b int) int {
-	if a >= b {
-		return a
+// addFlowIndex adds links to all other flows of the package to the package
+// index.
+func addFlowIndex(index *mdFile, flows []*sourcePart, r renderer) {
+	links := make([]link, 0, len(flows))
+	for _, f := range flows {
+		if f.mdFile == index {
+			continue
+		}
+		links = append(links, link{
+			name: f.name,
+			url:  filepath.Base(f.mdFile.name) + r.fileExt() + "#flow-" + strings.ToLower(f.name),
+			part: f,
+		})
+	}
+	r.flowIndex(index.buf, links)
+}
+func endMDFile(f *mdFile, r renderer) error {
+	if f == nil || f.buf == nil {
+		return nil
 	}
-	return b
+	r.endFile(f.buf)
+	return f.fImps.packDict.writeFile(f.name+r.fileExt(), f.buf.Bytes())
 }
@@ -1,6 +1,10 @@
 package goast_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/flowdev/go2md/goast"
@@ -64,6 +68,36 @@ func TestExtractFlowDSL(t *testing.T) {
 			expectedStart: "Start\n",
 			expectedFlow:  "my flow\n",
 			expectedEnd:   "   The end\ndoesn't want to come.\nBut it\nhas to.\n",
+		}, {
+			name:          "gofmt-code-block",
+			givenDoc:      "Start\n\nflow:\n\n\tmy flow\n\n\tmore flow\n\nEnd\n",
+			expectedStart: "Start\n",
+			expectedFlow:  "my flow\n\nmore flow\n",
+			expectedEnd:   "End\n",
+		}, {
+			name:          "capitalized-marker",
+			givenDoc:      "Start\n\nFlow:\n\tmy flow\nEnd\n",
+			expectedStart: "Start\n",
+			expectedFlow:  "my flow\n",
+			expectedEnd:   "End\n",
+		}, {
+			name:          "first-paragraph",
+			givenDoc:      "flow:\n    my flow\n\nEnd\n",
+			expectedStart: "",
+			expectedFlow:  "my flow\n",
+			expectedEnd:   "End\n",
+		}, {
+			name:          "marker-with-text",
+			givenDoc:      "Start\n\nflow: is great\n    my flow\n",
+			expectedStart: "Start\n\nflow: is great\n    my flow\n",
+			expectedFlow:  "",
+			expectedEnd:   "",
+		}, {
+			name:          "named-flows",
+			givenDoc:      "Start\n\nflow: first\n    a\n\nMiddle\n\nFLOW: second\n    b\nEnd\n",
+			expectedStart: "Start\n",
+			expectedFlow:  "a\n",
+			expectedEnd:   "Middle\n\nFLOW: second\n    b\nEnd\n",
 		},
 	}
 	for _, spec := range specs {
@@ -81,3 +115,276 @@ func TestExtractFlowDSL(t *testing.T) {
 		}
 	}
 }
+
+func TestPackageIndex(t *testing.T) {
+	const (
+		docGo = `// Package x does x.
+//
+// flow:
+//     in (int)-> [Foo] -> out
+package x
+`
+		indexGo = `package x
+
+// Foo does foo.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func Foo(i int) int { return bar(i) }
+
+func bar(i int) int { return i }
+`
+	)
+	specs := []struct {
+		name          string
+		givenFiles    map[string]string
+		expectedFiles map[string]string // file name to the start of the title
+		expectedError string
+	}{
+		{
+			name:       "index-go",
+			givenFiles: map[string]string{"doc.go": docGo, "index.go": indexGo},
+			expectedFiles: map[string]string{
+				"_index.html": "Flow Documentation For Package: x",
+				"index.html":  "Flow Documentation For File: index.go",
+			},
+		}, {
+			name: "function-package",
+			givenFiles: map[string]string{"doc.go": docGo,
+				"index.go": indexGo + "\nfunc Package() {}\n"},
+			expectedError: "clashes with the function",
+		}, {
+			name: "package-flows-twice",
+			givenFiles: map[string]string{"doc.go": docGo,
+				"index.go": strings.Replace(docGo, "// Package x does x.", "// Package x does more.", 1) +
+					indexGo[len("package x\n"):]},
+			expectedError: "defined twice",
+		}, {
+			name: "port-functions",
+			givenFiles: map[string]string{"ports.go": `package x
+
+// Foo_in handles the input.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func Foo_in(i int) int { return bar(i) }
+
+// Foo_addr handles addresses.
+//
+// flow:
+//     in (string)-> [baz] -> out
+func Foo_addr(s string) string { return baz(s) }
+
+func bar(i int) int       { return i }
+func baz(s string) string { return s }
+`},
+			expectedFiles: map[string]string{"ports.html": "Flow Documentation For File: ports.go"},
+		}, {
+			name: "same-name-methods",
+			givenFiles: map[string]string{"methods.go": `package x
+
+type A int
+type B int
+
+// Do does it for A.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func (a A) Do(i int) int { return bar(i) }
+
+// Do does it for B.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func (b B) Do(i int) int { return bar(i) }
+
+func bar(i int) int { return i }
+`},
+			expectedFiles: map[string]string{"methods.html": "Flow Documentation For File: methods.go"},
+		}, {
+			name: "flow-file-go-file",
+			givenFiles: map[string]string{"design.go": indexGo,
+				"design.flow": "flow: Design\n\nin (int)-> [Foo] -> out\n"},
+			expectedError: "clashes with the documentation of",
+		}, {
+			name: "flow-file-for-function",
+			givenFiles: map[string]string{"design.go": "package x\n\nfunc Foo(i int) int { return i }\n",
+				"foo.flow": "flow: Foo\n\nin (int)-> [bar] -> out\n"},
+			expectedFiles: map[string]string{"design.html": "Flow Documentation For File: design.go"},
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range spec.givenFiles {
+				writeTestFile(t, filepath.Join(dir, name), content)
+			}
+			err := runGo2md(t, dir, goast.Options{Format: goast.FormatHTML})
+			if spec.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), spec.expectedError) {
+					t.Fatalf("Expected error containing %q but got: %v", spec.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unable to process directory: %v", err)
+			}
+			for name, title := range spec.expectedFiles {
+				buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("Unable to read file: %v", err)
+				}
+				if !strings.Contains(string(buf), "<title>"+title) {
+					t.Errorf("Expected file %q to have the title %q but got:\n%s", name, title, buf)
+				}
+				if n := strings.Count(string(buf), "</html>"); n != 1 {
+					t.Errorf("Expected file %q to end once but it ends %d times", name, n)
+				}
+			}
+		})
+	}
+}
+
+func TestDataTypeImports(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "customer"), os.FileMode(0777)); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "x.go"), `package x
+
+import cust "./other"
+
+// Bla handles orders.
+//
+// flow:
+//     in (Order)-> [check] -> out
+func Bla(o Order) Order { return check(o) }
+
+func check(o Order) Order { var _ cust.Other; return o }
+`)
+	writeTestFile(t, filepath.Join(dir, "order.go"), `package x
+
+import cust "./customer"
+
+// Order is an order.
+type Order struct {
+	Customer cust.Customer
+}
+`)
+	writeTestFile(t, filepath.Join(dir, "customer", "customer.go"), `package customer
+
+// Customer is a customer.
+type Customer int
+`)
+	if err := runGo2md(t, dir, goast.Options{DataTypes: true}); err != nil {
+		t.Fatalf("Unable to process directory: %v", err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "x.md"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	expected := "Data type [cust.Customer](customer/customer.go#L4L4)"
+	if !strings.Contains(string(buf), expected) {
+		t.Errorf("Expected the link %q in:\n%s", expected, buf)
+	}
+}
+
+func TestPortReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "x.go"), `package x
+
+// Bla converts numbers.
+//
+// flow:
+//     in (int)-> [foo] (string)-> [bar] -> [baz] (bool)-> out
+func Bla(i int) bool { return baz(bar(foo(i))) }
+`)
+	if err := runGo2md(t, dir, goast.Options{PortRefs: true}); err != nil {
+		t.Fatalf("Unable to process directory: %v", err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "x.md"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	for _, expected := range []string{
+		"\nfoo | in (int) | out (string)\n",
+		"\nbar | in (string) | out\n",
+		"\nbaz | in | out (bool)\n",
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("Expected the line %q in:\n%s", expected[1:len(expected)-1], buf)
+		}
+	}
+}
+
+func TestNewPackageDictErrors(t *testing.T) {
+	specs := []struct {
+		name          string
+		givenOpts     goast.Options
+		expectedError string
+	}{
+		{
+			name:          "unknown-export",
+			givenOpts:     goast.Options{Exports: []string{"png"}},
+			expectedError: "unknown export format",
+		}, {
+			name:          "json-export",
+			givenOpts:     goast.Options{Format: goast.FormatJSON, Exports: []string{goast.ExportDOT}},
+			expectedError: `with the "json" format`,
+		}, {
+			name:          "yaml-overview",
+			givenOpts:     goast.Options{Format: goast.FormatYAML, Overview: true},
+			expectedError: `with the "yaml" format`,
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			_, err := goast.NewPackageDict(nil, t.TempDir(), spec.givenOpts)
+			if err == nil || !strings.Contains(err.Error(), spec.expectedError) {
+				t.Errorf("Expected error containing %q but got: %v", spec.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestModelPaths(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "pkg")
+	if err := os.Mkdir(dir, os.FileMode(0777)); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "x.go"), `package x
+
+// Bla does bla.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func Bla(i int) int { return bar(i) }
+
+func bar(i int) int { return i }
+`)
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to get working directory: %v", err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatalf("Unable to change to temporary directory: %v", err)
+	}
+	defer os.Chdir(oldDir)
+	packDict, err := goast.NewPackageDict(nil, root, goast.Options{Format: goast.FormatJSON})
+	if err != nil {
+		t.Fatalf("Unable to create package dictionary: %v", err)
+	}
+	if err = goast.ProcessDir(".", packDict); err != nil {
+		t.Fatalf("Unable to process directory: %v", err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "flows.json"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	for _, expected := range []string{`"dir": "pkg"`, `"name": "pkg/x.go"`, `"file": "pkg/x.go"`} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("Expected %s in:\n%s", expected, buf)
+		}
+	}
+}
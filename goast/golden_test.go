@@ -0,0 +1,160 @@
+package goast_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+const goldenDir = "testdata/golden"
+
+func TestProcessDirGolden(t *testing.T) {
+	specs := []struct {
+		name  string
+		given goast.Options
+	}{
+		{
+			name:  "markdown",
+			given: goast.Options{Overview: true},
+		}, {
+			name: "mermaid",
+			given: goast.Options{
+				Diagram:      goast.DiagramMermaid,
+				Exports:      []string{goast.ExportDOT, goast.ExportPlantUML},
+				PackageGraph: true,
+				Overview:     true,
+				Signatures:   true,
+				DataTypes:    true,
+				PortRefs:     true,
+			},
+		}, {
+			name: "html",
+			given: goast.Options{
+				Format:     goast.FormatHTML,
+				SVGLinks:   true,
+				Signatures: true,
+				DataTypes:  true,
+			},
+		}, {
+			name:  "json",
+			given: goast.Options{Format: goast.FormatJSON},
+		},
+	}
+
+	srcDir, err := filepath.Abs(filepath.Join(goldenDir, "src"))
+	if err != nil {
+		t.Fatalf("Unable to find source directory: %v", err)
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			var first map[string][]byte
+			for run, jobs := range []int{1, 4, 8} {
+				spec.given.Jobs = jobs
+				actual := generate(t, srcDir, spec.given)
+				if run == 0 {
+					first = actual
+					continue
+				}
+				compareFiles(t, "run 1", first, fmt.Sprintf("run %d", run+1), actual)
+			}
+
+			expectedDir := filepath.Join(goldenDir, spec.name)
+			if *update {
+				writeFiles(t, expectedDir, first)
+			}
+			compareFiles(t, "golden", readFiles(t, expectedDir, nil), "actual", first)
+		})
+	}
+}
+
+// generate runs go2md in a fresh copy of the source directory and returns
+// all generated files.
+func generate(t *testing.T, srcDir string, opts goast.Options) map[string][]byte {
+	src := readFiles(t, srcDir, nil)
+	tmpDir := t.TempDir()
+	writeFiles(t, tmpDir, src)
+
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to get working directory: %v", err)
+	}
+	if err = os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Unable to change to temporary directory: %v", err)
+	}
+	defer os.Chdir(oldDir)
+
+	packDict, err := goast.NewPackageDict(nil, tmpDir, opts)
+	if err != nil {
+		t.Fatalf("Unable to create package dictionary: %v", err)
+	}
+	if err = goast.ProcessDir(".", packDict); err != nil {
+		t.Fatalf("Unable to process directory: %v", err)
+	}
+	return readFiles(t, tmpDir, src)
+}
+
+func readFiles(t *testing.T, dir string, ignore map[string][]byte) map[string][]byte {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unable to read directory: %v", err)
+	}
+	files := make(map[string][]byte, len(infos))
+	for _, info := range infos {
+		if _, ok := ignore[info.Name()]; ok || info.IsDir() {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatalf("Unable to read file: %v", err)
+		}
+		files[info.Name()] = buf
+	}
+	return files
+}
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	if err := os.MkdirAll(dir, os.FileMode(0777)); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	for name, buf := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf, os.FileMode(0666)); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+}
+
+func compareFiles(t *testing.T, expectedName string, expected map[string][]byte,
+	actualName string, actual map[string][]byte,
+) {
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exp, eok := expected[name]
+		act, aok := actual[name]
+		switch {
+		case !eok:
+			t.Errorf("File %q is missing in %s.", name, expectedName)
+		case !aok:
+			t.Errorf("File %q is missing in %s.", name, actualName)
+		case !bytes.Equal(exp, act):
+			t.Errorf("File %q differs, %s:\n%s\n%s:\n%s", name, expectedName, exp, actualName, act)
+		}
+	}
+}
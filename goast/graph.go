@@ -0,0 +1,214 @@
+package goast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+	"github.com/flowdev/gflowparser/parser"
+	"github.com/flowdev/gparselib"
+)
+
+type flowNodeKind int
+
+const (
+	flowNodeComponent = flowNodeKind(iota)
+	flowNodeInPort
+	flowNodeOutPort
+)
+
+// flowNode is a component or an outer port of a flow.
+type flowNode struct {
+	id      string
+	kind    flowNodeKind
+	name    string
+	label   []string
+	comp    data.Type
+	plugins []data.Plugin
+}
+
+// flowEdge is an arrow between two nodes of a flow.
+type flowEdge struct {
+	from     *flowNode
+	to       *flowNode
+	fromPort string
+	toPort   string
+	data     string
+	types    []data.Type // data types (of the previous arrow if none are given)
+	explicit bool        // the data types are given on the arrow itself
+}
+
+// label returns the ports and data of the edge as a single string.
+func (e *flowEdge) label() string {
+	parts := make([]string, 0, 3)
+	for _, s := range []string{e.fromPort, e.data, e.toPort} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// flowGraph is a simple graph representation of a parsed flow.
+// Nodes and edges are kept in the order of their appearance in the DSL.
+type flowGraph struct {
+	nodes   []*flowNode
+	edges   []*flowEdge
+	nodeMap map[string]*flowNode
+}
+
+// parseFlowDSL parses the flow DSL into its semantic representation.
+func parseFlowDSL(flowDSL, flowName string) (data.Flow, error) {
+	pd := gparselib.NewParseData(flowName, flowDSL)
+	pFlow, err := parser.NewFlowParser()
+	if err != nil {
+		return data.Flow{}, err
+	}
+	pd, _ = pFlow.ParseFlow(pd, nil)
+	if _, err = parser.CheckFeedback(pd.Result); err != nil {
+		return data.Flow{}, err
+	}
+	return pd.Result.Value.(data.Flow), nil
+}
+
+// newFlowGraph converts a parsed flow into a graph.
+func newFlowGraph(flow data.Flow) *flowGraph {
+	g := &flowGraph{nodeMap: make(map[string]*flowNode)}
+	conts := make(map[int]*flowEdge) // arrows ending in a continuation
+
+	for _, partLine := range flow.Parts {
+		var prev *flowNode
+		var open *flowEdge
+		var types []data.Type
+		for i, part := range partLine {
+			switch p := part.(type) {
+			case data.Arrow:
+				if len(p.Data) > 0 {
+					types = p.Data
+				}
+				open = &flowEdge{
+					from:     prev,
+					fromPort: portToString(p.FromPort),
+					toPort:   portToString(p.ToPort),
+					data:     dataToString(p.Data),
+					types:    types,
+					explicit: len(p.Data) > 0,
+				}
+				if i == 0 { // outer input port or continuation
+					if p.FromPort.Continuation() {
+						cont := conts[p.FromPort.Index]
+						if cont == nil {
+							open = nil
+							continue
+						}
+						open.from, open.fromPort = cont.from, cont.fromPort
+						if len(p.Data) == 0 {
+							open.types, types = cont.types, cont.types
+							open.explicit = cont.explicit
+						}
+					} else {
+						open.from = g.addNode("in_"+p.FromPort.Name, flowNodeInPort,
+							[]string{open.fromPort}, data.Type{})
+						open.fromPort = ""
+					}
+				}
+				if i == len(partLine)-1 { // outer output port or continuation
+					if p.ToPort.Continuation() {
+						conts[p.ToPort.Index] = open
+					} else {
+						open.to = g.addNode("out_"+p.ToPort.Name, flowNodeOutPort,
+							[]string{open.toPort}, data.Type{})
+						open.toPort = ""
+						g.edges = append(g.edges, open)
+					}
+				}
+			case data.Component:
+				node := g.addNode("comp_"+p.Decl.Name, flowNodeComponent,
+					componentLabel(p), p.Decl.Type)
+				if !p.Decl.VagueType { // explicit declarations win
+					node.label = componentLabel(p)
+					node.comp = p.Decl.Type
+				}
+				if len(p.Plugins) > 0 {
+					node.plugins = p.Plugins
+				}
+				if open != nil {
+					open.to = node
+					g.edges = append(g.edges, open)
+					open = nil
+				}
+				prev = node
+			}
+		}
+	}
+	return g
+}
+func (g *flowGraph) addNode(id string, kind flowNodeKind, label []string, comp data.Type,
+) *flowNode {
+	if n := g.nodeMap[id]; n != nil {
+		return n
+	}
+	n := &flowNode{id: id, kind: kind, name: label[0], label: label, comp: comp}
+	g.nodeMap[id] = n
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+func componentLabel(comp data.Component) []string {
+	label := []string{comp.Decl.Name}
+	if typ := dslTypeToString(comp.Decl.Type); typ != comp.Decl.Name {
+		label = append(label, typ)
+	}
+	for _, plugin := range comp.Plugins {
+		types := make([]string, len(plugin.Types))
+		for i, t := range plugin.Types {
+			types[i] = dslTypeToString(t)
+		}
+		if plugin.Name != "" {
+			label = append(label, plugin.Name+" = "+strings.Join(types, ", "))
+		} else {
+			label = append(label, strings.Join(types, ", "))
+		}
+	}
+	return label
+}
+func portToString(port *data.Port) string {
+	if port == nil {
+		return ""
+	}
+	if port.HasIndex {
+		return fmt.Sprintf("%s[%d]", port.Name, port.Index)
+	}
+	return port.Name
+}
+func dataToString(types []data.Type) string {
+	if len(types) == 0 {
+		return ""
+	}
+	b := strings.Builder{}
+	b.WriteString("(")
+	for i, typ := range types {
+		if typ.Separator() {
+			b.WriteString(" |")
+			continue
+		}
+		if i > 0 && !types[i-1].Separator() {
+			b.WriteString(",")
+		}
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(dslTypeToString(typ))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+func dslTypeToString(typ data.Type) string {
+	if typ.ListType != nil {
+		return "[]" + dslTypeToString(*typ.ListType)
+	}
+	if typ.MapKeyType != nil {
+		return "map[" + dslTypeToString(*typ.MapKeyType) + "]" + dslTypeToString(*typ.MapValueType)
+	}
+	return typeToString(typ)
+}
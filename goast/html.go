@@ -0,0 +1,206 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"go/doc/comment"
+	"html"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	htmlStart = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flow Documentation For %[1]s: %[2]s</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.flow-diagram { overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Flow Documentation For %[1]s: %[2]s</h1>
+`
+	htmlFlowStart = `
+<h2 id="flow-%s">Flow: <a href="%s#L%dL%d">%s</a></h2>
+`
+	htmlEnd = `</body>
+</html>
+`
+	xmlHeader = "<?xml"
+)
+
+// htmlRenderer writes self-contained HTML pages with inline SVG diagrams.
+type htmlRenderer struct{}
+
+func (htmlRenderer) fileExt() string {
+	return ".html"
+}
+
+func (htmlRenderer) startFile(buf *bytes.Buffer, goFile string) {
+	buf.WriteString(fmt.Sprintf(htmlStart, "File", html.EscapeString(goFile)))
+}
+
+func (htmlRenderer) startIndex(buf *bytes.Buffer, pkgName string) {
+	buf.WriteString(fmt.Sprintf(htmlStart, "Package", html.EscapeString(pkgName)))
+}
+
+func (htmlRenderer) startFlow(buf *bytes.Buffer, f *sourcePart, doc string) {
+	buf.WriteString(fmt.Sprintf(htmlFlowStart,
+		strings.ToLower(f.name), html.EscapeString(filepath.Base(f.goFile)), f.start, f.end,
+		html.EscapeString(f.name)))
+	buf.Write(docToHTML(doc))
+}
+
+func (htmlRenderer) diagram(buf *bytes.Buffer, f *sourcePart, dsl string, svg []byte) error {
+	buf.WriteString(`<div class="flow-diagram">` + "\n")
+	buf.Write(stripXMLHeader(svg))
+	buf.WriteString("</div>\n")
+	return nil
+}
+
+func (htmlRenderer) references(buf *bytes.Buffer, compLinks, dataLinks []link) {
+	buf.WriteString("<table>\n<thead><tr><th>Components</th><th>Data</th></tr></thead>\n<tbody>\n")
+	for i := 0; i < len(compLinks) || i < len(dataLinks); i++ {
+		buf.WriteString("<tr><td>")
+		if i < len(compLinks) {
+			buf.WriteString(htmlLink(compLinks[i]))
+		}
+		buf.WriteString("</td><td>")
+		if i < len(dataLinks) {
+			buf.WriteString(htmlLink(dataLinks[i]))
+		}
+		buf.WriteString("</td></tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+}
+
+func (htmlRenderer) portReferences(buf *bytes.Buffer, refs []compRefs) {
+	buf.WriteString("<table>\n<thead><tr><th>Component</th><th>Input</th><th>Output</th></tr></thead>\n<tbody>\n")
+	for _, r := range refs {
+		buf.WriteString("<tr><td>" + htmlLink(r.comp) + "</td><td>")
+		buf.WriteString(strings.Join(formatPortRefs(r.ins, htmlLink), "<br>") + "</td><td>")
+		buf.WriteString(strings.Join(formatPortRefs(r.outs, htmlLink), "<br>") + "</td></tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+}
+
+func (htmlRenderer) signatures(buf *bytes.Buffer, sigLinks []link) {
+	buf.WriteString("<table>\n<thead><tr><th>Component</th><th>Input</th><th>Output</th>" +
+		"<th>Plugins</th><th>Description</th></tr></thead>\n<tbody>\n")
+	for _, l := range sigLinks {
+		sig := l.part.sig
+		buf.WriteString("<tr><td>" + htmlLink(l) + "</td><td>" + htmlCodeList([]string{sig.input()}))
+		buf.WriteString("</td><td>" + htmlCodeList(sig.output()) + "</td><td>" + htmlCodeList(sig.plugins))
+		buf.WriteString("</td><td>" + html.EscapeString(sig.summary) + "</td></tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+}
+
+func htmlCodeList(list []string) string {
+	codes := make([]string, len(list))
+	for i, s := range list {
+		codes[i] = "<code>" + html.EscapeString(s) + "</code>"
+	}
+	return strings.Join(codes, ", ")
+}
+
+func (htmlRenderer) dataTypes(buf *bytes.Buffer, types []typeEntry) {
+	for _, e := range types {
+		def := e.def
+		buf.WriteString("<p>Data type " + htmlLink(e.link) + ": ")
+		if def.kind == "" {
+			buf.WriteString(htmlTypeExpr(e, def.underlying))
+		} else {
+			buf.WriteString("<code>" + def.kind + "</code>")
+		}
+		buf.WriteString("</p>\n")
+		if def.summary != "" {
+			buf.WriteString("<p>" + html.EscapeString(def.summary) + "</p>\n")
+		}
+		if len(def.members) == 0 {
+			continue
+		}
+		if def.kind == typeKindInterface {
+			buf.WriteString("<table>\n<thead><tr><th>Method</th><th>Signature</th><th>Description</th></tr></thead>\n<tbody>\n")
+		} else {
+			buf.WriteString("<table>\n<thead><tr><th>Field</th><th>Type</th><th>Description</th></tr></thead>\n<tbody>\n")
+		}
+		for _, m := range def.members {
+			name := "<em>embedded</em>"
+			if m.name != "" {
+				name = "<code>" + html.EscapeString(m.name) + "</code>"
+			}
+			buf.WriteString("<tr><td>" + name + "</td><td>" + htmlTypeExpr(e, m.typ))
+			buf.WriteString("</td><td>" + html.EscapeString(m.doc) + "</td></tr>\n")
+		}
+		buf.WriteString("</tbody>\n</table>\n")
+	}
+}
+
+// htmlTypeExpr links the type expression if it references exactly one known
+// type and lists the links after it otherwise.
+func htmlTypeExpr(e typeEntry, te typeExpr) string {
+	code := "<code>" + html.EscapeString(te.expr) + "</code>"
+	links := e.refLinks(te)
+	if len(links) == 0 {
+		return code
+	}
+	if len(links) == 1 {
+		return `<a href="` + html.EscapeString(links[0].url) + `">` + code + "</a>"
+	}
+	names := make([]string, len(links))
+	for i, l := range links {
+		names[i] = htmlLink(l)
+	}
+	return code + " (" + strings.Join(names, ", ") + ")"
+}
+
+func (htmlRenderer) endFlow(buf *bytes.Buffer, doc string) {
+	buf.Write(docToHTML(doc))
+}
+
+func (htmlRenderer) flowIndex(buf *bytes.Buffer, flowLinks []link) {
+	if len(flowLinks) == 0 {
+		return
+	}
+	buf.WriteString("<h2>All Flows Of The Package</h2>\n<ul>\n")
+	for _, l := range flowLinks {
+		buf.WriteString("<li>" + htmlLink(l) + "</li>\n")
+	}
+	buf.WriteString("</ul>\n")
+}
+
+func (htmlRenderer) endFile(buf *bytes.Buffer) {
+	buf.WriteString(htmlEnd)
+}
+
+func htmlLink(l link) string {
+	if l.url == "" {
+		return html.EscapeString(l.name)
+	}
+	return `<a href="` + html.EscapeString(l.url) + `">` + html.EscapeString(l.name) + "</a>"
+}
+
+// docToHTML converts a Go doc comment text to HTML.
+func docToHTML(doc string) []byte {
+	var p comment.Parser
+	var pr comment.Printer
+	return pr.HTML(p.Parse(doc))
+}
+
+// stripXMLHeader removes the XML declaration so the SVG can be embedded
+// into another document.
+func stripXMLHeader(svg []byte) []byte {
+	if !bytes.HasPrefix(svg, []byte(xmlHeader)) {
+		return svg
+	}
+	if i := bytes.IndexByte(svg, '\n'); i >= 0 {
+		return svg[i+1:]
+	}
+	return svg
+}
@@ -0,0 +1,780 @@
+package goast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Diagnostic severities and completion item kinds of the language server
+// protocol.
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+	lspKindFunction    = 3
+	lspKindClass       = 7
+)
+
+var (
+	lspErrorRegex = regexp.MustCompile(`ERROR: File '[^']*', line (\d+), column (\d+):\n[^\n]*\n([^\n]*)`)
+	lspWordRegex  = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?`)
+)
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+type lspDidOpen struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+type lspDidChange struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// lspServer is a language server for flows in Go comments.
+type lspServer struct {
+	packDict *packageDict
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]string // content of the open documents by URI
+	shutdown bool
+}
+
+// LSP runs a language server for flows in Go comments.
+// It communicates via JSON-RPC with the client using the given reader and
+// writer (usually stdin and stdout) until the client exits.
+// It supports diagnostics, go to definition, hover and completion.
+func LSP(in io.Reader, out io.Writer, packDict *packageDict) error {
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return fmt.Errorf("unable to get working directory: %w", err)
+	}
+	packDict.cwd = cwd
+	s := &lspServer{
+		packDict: packDict,
+		in:       bufio.NewReader(in),
+		out:      out,
+		docs:     make(map[string]string),
+	}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil { // notification
+			continue
+		}
+		resp := &lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rerr}
+		if result == nil && rerr == nil {
+			resp.Result = json.RawMessage("null")
+		}
+		if err = s.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):])); err != nil {
+				return nil, fmt.Errorf("broken message header %q: %w", line, err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message header without content length")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(s.in, buf); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(buf, msg); err != nil {
+		return nil, fmt.Errorf("broken message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(buf)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(buf)
+	return err
+}
+
+func (s *lspServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full content
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"[", "("},
+				},
+			},
+			"serverInfo": map[string]string{"name": "go2md", "version": Version},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		p := lspDidOpen{}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{Code: -32602, Message: err.Error()}
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didChange":
+		p := lspDidChange{}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{Code: -32602, Message: err.Error()}
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[n-1].Text
+		}
+		s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didClose":
+		p := lspDidOpen{}
+		if err := json.Unmarshal(msg.Params, &p); err == nil {
+			delete(s.docs, p.TextDocument.URI)
+		}
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		p := lspTextDocumentPosition{}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{Code: -32602, Message: err.Error()}
+		}
+		f := s.analyze(p.TextDocument.URI)
+		if f == nil {
+			return nil, nil
+		}
+		pos := f.bytePosition(p.Position)
+		switch msg.Method {
+		case "textDocument/definition":
+			return f.definition(pos), nil
+		case "textDocument/hover":
+			return f.hover(pos, s.docs), nil
+		default:
+			return f.completion(pos), nil
+		}
+	default:
+		if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+			return nil, &lspError{Code: -32601, Message: "method not found: " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+func (s *lspServer) publishDiagnostics(uri string) {
+	diags := []lspDiagnostic{}
+	if f := s.analyze(uri); f != nil {
+		diags = f.diagnostics()
+	}
+	err := s.write(&lspMessage{
+		Method: "textDocument/publishDiagnostics",
+		Params: mustMarshal(map[string]interface{}{"uri": uri, "diagnostics": diags}),
+	})
+	if err != nil {
+		log.Printf("ERROR: Unable to publish diagnostics: %v", err)
+	}
+}
+func mustMarshal(v interface{}) json.RawMessage {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// lspFile is the analysis of one Go file with flows.
+// All positions inside of it use byte offsets for the character.
+// They are converted to UTF-16 code units only for the client.
+type lspFile struct {
+	mdFile   *mdFile // for resolving components and types
+	partMap  map[string]*sourcePart
+	lines    []string // of the document
+	flows    []*lspFlow
+	warnings []lspDiagnostic // for things that look like flows but aren't
+}
+
+// lspFlow is a flow in a comment of the Go file.
+type lspFlow struct {
+	name   string
+	dsl    string
+	lines  []lspLine // position of every DSL line in the Go file
+	tokens []lspToken
+}
+
+// lspLine maps a DSL line to the Go file. All values are zero based and
+// the line is -1 if the DSL line can't be found.
+type lspLine struct {
+	line   int
+	offset int // column of the Go file = offset + column of the DSL line
+}
+
+// lspToken is a name in the DSL that could reference a component or type.
+type lspToken struct {
+	line, col int // position in the Go file
+	text      string
+	isType    bool // data type in parentheses
+}
+
+// analyze parses the document and the rest of its package and finds all
+// flows in it.
+func (s *lspServer) analyze(uri string) *lspFile {
+	text, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+	fileName := uriToPath(uri)
+	s.packDict.startRun() // other packages might have changed, too
+	fset := token.NewFileSet()
+	astf, err := parser.ParseFile(fset, fileName, text, parser.ParseComments)
+	if astf == nil {
+		log.Printf("ERROR: Unable to parse file '%s': %v", fileName, err)
+		return nil
+	}
+
+	partMap := make(map[string]*sourcePart)
+	dir := filepath.Dir(fileName)
+	pkgs, _ := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return excludeTests(fi) && filepath.Join(dir, fi.Name()) != fileName
+	}, parser.ParseComments)
+	if pkg := pkgs[astf.Name.Name]; pkg != nil {
+		for _, name := range sortedFileNames(pkg.Files) {
+			findSourceParts(partMap, nil, pkg.Files[name], name, "", fset)
+		}
+	}
+	findSourceParts(partMap, nil, astf, fileName, "", fset)
+
+	f := &lspFile{
+		mdFile:  &mdFile{name: goNameToBase(fileName), fImps: newFileImps(astf.Imports, s.packDict, fset)},
+		partMap: partMap,
+		lines:   strings.Split(text, "\n"),
+	}
+	names := make(map[*ast.CommentGroup]string)
+	if astf.Doc != nil {
+		names[astf.Doc] = packageFlowName
+	}
+	for _, idecl := range astf.Decls {
+		switch decl := idecl.(type) {
+		case *ast.FuncDecl:
+			if decl.Doc != nil {
+				names[decl.Doc] = decl.Name.Name
+			}
+		case *ast.GenDecl:
+			for _, s := range decl.Specs {
+				switch spec := s.(type) {
+				case *ast.TypeSpec:
+					names[specDoc(spec.Doc, decl)] = spec.Name.Name
+				case *ast.ValueSpec:
+					names[specDoc(spec.Doc, decl)] = spec.Names[0].Name
+				}
+			}
+		}
+	}
+	delete(names, nil)
+	for _, cg := range astf.Comments {
+		blocks, warnings := extractFlows(cg.Text())
+		line := fset.Position(cg.Pos()).Line - 1
+		for _, w := range warnings {
+			f.warnings = append(f.warnings, lspDiagnostic{
+				Range:    lspRange{Start: lspPosition{Line: line + w.line}, End: lspPosition{Line: line + w.line + 1}},
+				Severity: lspSeverityWarning,
+				Source:   "go2md",
+				Message:  w.msg,
+			})
+		}
+		raws := rawCommentLines(cg, fset)
+		next := 0
+		for i, b := range blocks {
+			if b.dsl == "" {
+				continue
+			}
+			name := b.name
+			if name == "" {
+				name = names[cg]
+			}
+			if name == "" {
+				name = "flow"
+			} else if b.name == "" && i > 0 {
+				name += strconv.Itoa(i + 1)
+			}
+			flow := &lspFlow{name: name, dsl: b.dsl}
+			flow.lines, next = mapDSLLines(b.dsl, raws, next)
+			flow.tokens = findDSLTokens(b.dsl, flow.lines)
+			f.flows = append(f.flows, flow)
+		}
+	}
+	return f
+}
+
+// rawCommentLine is a line of a comment as it is in the Go file.
+type rawCommentLine struct {
+	line, col int
+	text      string
+}
+
+func rawCommentLines(cg *ast.CommentGroup, fset *token.FileSet) []rawCommentLine {
+	raws := make([]rawCommentLine, 0, 32)
+	for _, c := range cg.List {
+		pos := fset.Position(c.Pos())
+		for i, text := range strings.Split(c.Text, "\n") {
+			col := 0
+			if i == 0 {
+				col = pos.Column - 1
+			}
+			raws = append(raws, rawCommentLine{line: pos.Line - 1 + i, col: col, text: text})
+		}
+	}
+	return raws
+}
+
+// mapDSLLines finds the position of every DSL line in the comment lines.
+// The search starts at the flow marker found at or after the index start.
+// The index after the last DSL line is returned, too.
+func mapDSLLines(dsl string, raws []rawCommentLine, start int) ([]lspLine, int) {
+	for k := start; k < len(raws); k++ {
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(raws[k].text), "//"))
+		text = strings.TrimSpace(strings.TrimPrefix(text, "/*"))
+		if flowMarkerRegex.MatchString(text) {
+			start = k + 1
+			break
+		}
+	}
+	dslLines := strings.Split(strings.TrimSuffix(dsl, "\n"), "\n")
+	lines := make([]lspLine, len(dslLines))
+	j := start
+	for i, content := range dslLines {
+		lines[i] = lspLine{line: -1}
+		trimmed := strings.TrimSpace(content)
+		if trimmed == "" {
+			continue
+		}
+		for k := j; k < len(raws); k++ {
+			if idx := strings.Index(raws[k].text, trimmed); idx >= 0 {
+				lead := len(content) - len(strings.TrimLeft(content, " \t"))
+				lines[i] = lspLine{line: raws[k].line, offset: raws[k].col + idx - lead}
+				j = k + 1
+				break
+			}
+		}
+	}
+	return lines, j
+}
+
+// findDSLTokens finds all names inside of brackets and parentheses.
+func findDSLTokens(dsl string, lines []lspLine) []lspToken {
+	tokens := make([]lspToken, 0, 32)
+	for i, content := range strings.Split(strings.TrimSuffix(dsl, "\n"), "\n") {
+		if i >= len(lines) || lines[i].line < 0 {
+			continue
+		}
+		for _, m := range lspWordRegex.FindAllStringIndex(content, -1) {
+			open := openBracket(content[:m[0]])
+			if open == 0 {
+				continue // port name
+			}
+			tokens = append(tokens, lspToken{
+				line:   lines[i].line,
+				col:    lines[i].offset + m[0],
+				text:   content[m[0]:m[1]],
+				isType: open == '(',
+			})
+		}
+	}
+	return tokens
+}
+
+// openBracket returns the innermost bracket that isn't closed at the end
+// of the text or 0.
+func openBracket(text string) byte {
+	stack := make([]byte, 0, 4)
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[', '(':
+			stack = append(stack, text[i])
+		case ']', ')':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if len(stack) == 0 {
+		return 0
+	}
+	return stack[len(stack)-1]
+}
+
+// diagnostics reports syntax errors and unresolved components of all flows.
+func (f *lspFile) diagnostics() []lspDiagnostic {
+	diags := append([]lspDiagnostic{}, f.warnings...)
+	for _, flow := range f.flows {
+		pFlow, err := parseFlowDSL(flow.dsl, flow.name)
+		if err != nil {
+			diags = append(diags, flow.syntaxError(err))
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, n := range newFlowGraph(pFlow).nodes {
+			if n.kind != flowNodeComponent {
+				continue
+			}
+			name := dslTypeToString(n.comp)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if getLinkForComponent(n.comp, f.partMap, f.mdFile).part != nil {
+				continue
+			}
+			rng := flow.firstLineRange()
+			for _, t := range flow.tokens {
+				if t.text == name && !t.isType {
+					rng = t.textRange()
+					break
+				}
+			}
+			diags = append(diags, lspDiagnostic{
+				Range:    rng,
+				Severity: lspSeverityWarning,
+				Source:   "go2md",
+				Message:  "unresolved component: " + name,
+			})
+		}
+	}
+	for i := range diags {
+		diags[i].Range = f.utf16Range(diags[i].Range)
+	}
+	return diags
+}
+
+// syntaxError converts the errors of the flow parser into a diagnostic at
+// the position that has been reached by the parser.
+func (flow *lspFlow) syntaxError(err error) lspDiagnostic {
+	line, col := 0, 0
+	msgs := make([]string, 0, 4)
+	for _, m := range lspErrorRegex.FindAllStringSubmatch(err.Error(), -1) {
+		l, _ := strconv.Atoi(m[1])
+		c, _ := strconv.Atoi(m[2])
+		if l > line || (l == line && c > col) {
+			line, col, msgs = l, c, msgs[:0]
+		}
+		if l == line && c == col {
+			msgs = append(msgs, m[3])
+		}
+	}
+	rng := flow.firstLineRange()
+	if line > 0 && line <= len(flow.lines) && flow.lines[line-1].line >= 0 {
+		fl := flow.lines[line-1]
+		rng.Start = lspPosition{Line: fl.line, Character: fl.offset + col - 1}
+		rng.End = lspPosition{Line: fl.line, Character: fl.offset + col}
+	}
+	msg := strings.Join(msgs, " ")
+	if msg == "" {
+		msg = err.Error()
+	}
+	return lspDiagnostic{Range: rng, Severity: lspSeverityError, Source: "go2md", Message: msg}
+}
+func (flow *lspFlow) firstLineRange() lspRange {
+	for _, l := range flow.lines {
+		if l.line >= 0 {
+			return lspRange{Start: lspPosition{Line: l.line}, End: lspPosition{Line: l.line + 1}}
+		}
+	}
+	return lspRange{}
+}
+func (t lspToken) textRange() lspRange {
+	return lspRange{
+		Start: lspPosition{Line: t.line, Character: t.col},
+		End:   lspPosition{Line: t.line, Character: t.col + len(t.text)},
+	}
+}
+
+// utf16Range converts the characters of the range from byte offsets to
+// UTF-16 code units as required by the protocol.
+func (f *lspFile) utf16Range(rng lspRange) lspRange {
+	rng.Start.Character = f.utf16Column(rng.Start)
+	rng.End.Character = f.utf16Column(rng.End)
+	return rng
+}
+func (f *lspFile) utf16Column(pos lspPosition) int {
+	if pos.Line < 0 || pos.Line >= len(f.lines) {
+		return pos.Character
+	}
+	text := f.lines[pos.Line]
+	if pos.Character > len(text) {
+		return len(utf16.Encode([]rune(text))) + pos.Character - len(text)
+	}
+	return len(utf16.Encode([]rune(text[:pos.Character])))
+}
+
+// bytePosition converts the character of a position from the client from
+// UTF-16 code units to a byte offset.
+func (f *lspFile) bytePosition(pos lspPosition) lspPosition {
+	if pos.Line < 0 || pos.Line >= len(f.lines) {
+		return pos
+	}
+	text := f.lines[pos.Line]
+	n := 0
+	for i, r := range text {
+		if n >= pos.Character {
+			pos.Character = i
+			return pos
+		}
+		n += len(utf16.Encode([]rune{r}))
+	}
+	pos.Character = len(text) + pos.Character - n
+	return pos
+}
+
+// tokenAt returns the token at the given position or nil.
+func (f *lspFile) tokenAt(pos lspPosition) *lspToken {
+	for _, flow := range f.flows {
+		for i, t := range flow.tokens {
+			if t.line == pos.Line && t.col <= pos.Character && pos.Character <= t.col+len(t.text) {
+				return &flow.tokens[i]
+			}
+		}
+	}
+	return nil
+}
+
+// resolve finds the source part of a component or type.
+func (f *lspFile) resolve(t *lspToken) *sourcePart {
+	pack, local := "", t.text
+	if i := strings.LastIndex(t.text, "."); i >= 0 {
+		pack, local = t.text[:i], t.text[i+1:]
+	}
+	markers := []string{markerFlow, markerFunc, markerType}
+	if t.isType {
+		markers = []string{markerType, markerFlow, markerFunc}
+	}
+	for _, marker := range markers {
+		var part *sourcePart
+		if pack == "" {
+			part = f.partMap[marker+local]
+		} else {
+			part = f.mdFile.fImps.getPartFor(pack, marker+local)
+		}
+		if part != nil {
+			return part
+		}
+	}
+	return nil
+}
+
+func (f *lspFile) definition(pos lspPosition) interface{} {
+	t := f.tokenAt(pos)
+	if t == nil {
+		return nil
+	}
+	part := f.resolve(t)
+	if part == nil {
+		return nil
+	}
+	line := part.start - 1
+	return lspLocation{
+		URI:   pathToURI(part.goFile),
+		Range: lspRange{Start: lspPosition{Line: line}, End: lspPosition{Line: line}},
+	}
+}
+
+func (f *lspFile) hover(pos lspPosition, docs map[string]string) interface{} {
+	t := f.tokenAt(pos)
+	if t == nil {
+		return nil
+	}
+	part := f.resolve(t)
+	if part == nil {
+		return nil
+	}
+	content := "```go\n" + declarationOf(part, docs) + "\n```\n"
+	if part.doc != "" {
+		content += "\n" + part.doc
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": content},
+		"range":    f.utf16Range(t.textRange()),
+	}
+}
+
+// declarationOf returns the source code of the declaration of a type or the
+// signature of a function.
+func declarationOf(part *sourcePart, docs map[string]string) string {
+	text, ok := docs[pathToURI(part.goFile)]
+	if !ok {
+		buf, err := ioutil.ReadFile(part.goFile)
+		if err != nil {
+			return part.name
+		}
+		text = string(buf)
+	}
+	lines := strings.Split(text, "\n")
+	if part.start < 1 || part.start > len(lines) {
+		return part.name
+	}
+	end := part.end
+	if end > len(lines) || end < part.start {
+		end = part.start
+	}
+	decl := make([]string, 0, 8)
+	for _, line := range lines[part.start-1 : end] {
+		if part.kind != sourcePartType {
+			if i := strings.LastIndex(line, "{"); i >= 0 {
+				decl = append(decl, strings.TrimSpace(line[:i]))
+				break
+			}
+		}
+		decl = append(decl, line)
+		if len(decl) >= 16 {
+			decl = append(decl, "\t// ...")
+			break
+		}
+	}
+	return strings.Join(decl, "\n")
+}
+
+func (f *lspFile) completion(pos lspPosition) interface{} {
+	isType := false
+	inFlow := false
+	for _, flow := range f.flows {
+		for _, l := range flow.lines {
+			if l.line == pos.Line {
+				inFlow = true
+			}
+		}
+		for _, t := range flow.tokens {
+			if t.line == pos.Line && t.col <= pos.Character {
+				isType = t.isType
+			}
+		}
+	}
+	items := []lspCompletionItem{}
+	if !inFlow {
+		return items
+	}
+	keys := make([]string, 0, len(f.partMap))
+	for key := range f.partMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		part := f.partMap[key]
+		switch part.kind {
+		case sourcePartType:
+			items = append(items, lspCompletionItem{Label: part.name, Kind: lspKindClass, Detail: "type"})
+		case sourcePartFlow, sourcePartFunc:
+			if isType {
+				continue
+			}
+			detail := "func"
+			if part.kind == sourcePartFlow {
+				detail = "flow"
+			}
+			items = append(items, lspCompletionItem{Label: part.name, Kind: lspKindFunction, Detail: detail})
+		}
+	}
+	return items
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+func pathToURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
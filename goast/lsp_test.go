@@ -0,0 +1,187 @@
+package goast_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestLSP(t *testing.T) {
+	fileName, err := filepath.Abs(filepath.Join(goldenDir, "src", "sample.go"))
+	if err != nil {
+		t.Fatalf("Unable to find source file: %v", err)
+	}
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Unable to read source file: %v", err)
+	}
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(fileName)}).String()
+	text := string(buf)
+	broken := strings.Replace(text, "[foo2] -> out", "[foo2 -> out", 1)
+	unresolved := strings.Replace(text, "[foo2] -> out", "[foo4] -> out", 1)
+	// 13 bytes but only 10 UTF-16 code units:
+	nonASCII := strings.Replace(text, "//     in (Tint1)-> [foo1]", "//     /* ä😀 */ in (Tint1)-> [foo1]", 1)
+	nonASCIIUnresolved := strings.Replace(nonASCII, "[foo2] -> out", "[foo4] -> out", 1)
+	// DSL of Bla: `//     in (Tint1)-> [foo1] -> [BlaSome] -> [foo2] -> out` in line 18
+	specs := []struct {
+		name           string
+		givenMethod    string
+		givenParams    interface{}
+		expectedResult string
+	}{
+		{
+			name:           "diagnostics-ok",
+			givenMethod:    "textDocument/didOpen",
+			givenParams:    map[string]interface{}{"textDocument": map[string]string{"uri": uri, "text": text}},
+			expectedResult: `"diagnostics":[]`,
+		}, {
+			name:        "diagnostics-syntax-error",
+			givenMethod: "textDocument/didChange",
+			givenParams: map[string]interface{}{
+				"textDocument":   map[string]string{"uri": uri},
+				"contentChanges": []map[string]string{{"text": broken}},
+			},
+			expectedResult: `"range":{"start":{"line":17,"character":43},"end":{"line":17,"character":44}},"severity":1`,
+		}, {
+			name:        "diagnostics-unresolved",
+			givenMethod: "textDocument/didChange",
+			givenParams: map[string]interface{}{
+				"textDocument":   map[string]string{"uri": uri},
+				"contentChanges": []map[string]string{{"text": unresolved}},
+			},
+			expectedResult: `"range":{"start":{"line":17,"character":44},"end":{"line":17,"character":48}},"severity":2,"source":"go2md","message":"unresolved component: foo4"`,
+		}, {
+			name:        "definition-func",
+			givenMethod: "textDocument/definition",
+			givenParams: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri},
+				"position":     map[string]int{"line": 17, "character": 21},
+			},
+			expectedResult: `"result":{"uri":"` + uri + `","range":{"start":{"line":25,"character":0}`,
+		}, {
+			name:        "definition-type",
+			givenMethod: "textDocument/definition",
+			givenParams: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri},
+				"position":     map[string]int{"line": 17, "character": 12},
+			},
+			expectedResult: `"result":{"uri":"` + uri + `","range":{"start":{"line":7,"character":0}`,
+		}, {
+			name:        "hover",
+			givenMethod: "textDocument/hover",
+			givenParams: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri},
+				"position":     map[string]int{"line": 17, "character": 21},
+			},
+			expectedResult: `"value":"` + "```go\\nfunc foo1(i Tint1) Tint1\\n```\\n" + `"`,
+		}, {
+			name:        "completion",
+			givenMethod: "textDocument/completion",
+			givenParams: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri},
+				"position":     map[string]int{"line": 17, "character": 12},
+			},
+			expectedResult: `"result":[{"label":"Blaer","kind":7,"detail":"type"},{"label":"Checker","kind":7,"detail":"type"},{"label":"Order","kind":7,"detail":"type"},{"label":"Pipeline","kind":7,"detail":"type"},{"label":"TBlaer","kind":7,"detail":"type"},` +
+				`{"label":"Tint1","kind":7,"detail":"type"},{"label":"t2","kind":7,"detail":"type"},{"label":"t3","kind":7,"detail":"type"}]`,
+		}, {
+			name:        "diagnostics-non-ascii",
+			givenMethod: "textDocument/didChange",
+			givenParams: map[string]interface{}{
+				"textDocument":   map[string]string{"uri": uri},
+				"contentChanges": []map[string]string{{"text": nonASCIIUnresolved}},
+			},
+			expectedResult: `"range":{"start":{"line":17,"character":54},"end":{"line":17,"character":58}},"severity":2,"source":"go2md","message":"unresolved component: foo4"`,
+		}, {
+			name:        "diagnostics-non-ascii-ok",
+			givenMethod: "textDocument/didChange",
+			givenParams: map[string]interface{}{
+				"textDocument":   map[string]string{"uri": uri},
+				"contentChanges": []map[string]string{{"text": nonASCII}},
+			},
+			expectedResult: `"diagnostics":[]`,
+		}, {
+			name:        "hover-non-ascii",
+			givenMethod: "textDocument/hover",
+			givenParams: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri},
+				"position":     map[string]int{"line": 17, "character": 55},
+			},
+			expectedResult: `"range":{"start":{"line":17,"character":54},"end":{"line":17,"character":58}}`,
+		},
+	}
+
+	in := &bytes.Buffer{}
+	writeLSPMessage(in, 0, "initialize", map[string]interface{}{})
+	for i, spec := range specs {
+		writeLSPMessage(in, i+1, spec.givenMethod, spec.givenParams)
+	}
+	writeLSPMessage(in, len(specs)+1, "shutdown", nil)
+	writeLSPMessage(in, -1, "exit", nil)
+
+	packDict, err := goast.NewPackageDict(nil, filepath.Dir(fileName), goast.Options{})
+	if err != nil {
+		t.Fatalf("Unable to create package dictionary: %v", err)
+	}
+	out := &bytes.Buffer{}
+	if err = goast.LSP(in, out, packDict); err != nil {
+		t.Fatalf("Language server failed: %v", err)
+	}
+
+	msgs := readLSPMessages(t, out)
+	if len(msgs) != len(specs)+2 {
+		t.Fatalf("Expected %d messages but got %d: %q", len(specs)+2, len(msgs), msgs)
+	}
+	for i, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			if !strings.Contains(msgs[i+1], spec.expectedResult) {
+				t.Errorf("Expected message to contain:\n%s\nbut got:\n%s", spec.expectedResult, msgs[i+1])
+			}
+		})
+	}
+}
+
+func writeLSPMessage(w io.Writer, id int, method string, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if id >= 0 && !strings.HasPrefix(method, "textDocument/did") {
+		msg["id"] = id
+	}
+	if params != nil {
+		msg["params"] = params
+	}
+	buf, _ := json.Marshal(msg)
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+}
+
+func readLSPMessages(t *testing.T, r io.Reader) []string {
+	br := bufio.NewReader(r)
+	msgs := make([]string, 0, 16)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Unable to read message header: %v", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("Broken message header %q: %v", line, err)
+		}
+		br.ReadString('\n')
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(br, buf); err != nil {
+			t.Fatalf("Unable to read message: %v", err)
+		}
+		msgs = append(msgs, string(buf))
+	}
+}
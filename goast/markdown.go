@@ -0,0 +1,211 @@
+package goast
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	mdStart              = "# Flow Documentation For File: "
+	mdIndexStart         = "# Flow Documentation For Package: "
+	mdFlowIndexStart     = "\n## All Flows Of The Package\n\n"
+	flowStart            = "\n## Flow: [%s](%s#L%dL%d)\n"
+	referenceTableHeader = `Components | Data
+---------- | -----
+`
+	portRefsTableHeader = `Component | Input | Output
+--------- | ----- | ------
+`
+	signatureTableHeader = `Component | Input | Output | Plugins | Description
+--------- | ----- | ------ | ------- | -----------
+`
+	dataFieldsHeader = `Field | Type | Description
+----- | ---- | -----------
+`
+	dataMethodsHeader = `Method | Signature | Description
+------ | --------- | -----------
+`
+)
+
+// markdownRenderer writes Markdown files with the diagrams in separate SVG
+// files, embedded according to the embed mode or as Mermaid flowcharts.
+type markdownRenderer struct {
+	embed       string
+	diagramType string
+}
+
+func (markdownRenderer) fileExt() string {
+	return ".md"
+}
+
+func (markdownRenderer) startFile(buf *bytes.Buffer, goFile string) {
+	buf.WriteString(mdStart + goFile + "\n\n")
+}
+
+func (markdownRenderer) startIndex(buf *bytes.Buffer, pkgName string) {
+	buf.WriteString(mdIndexStart + pkgName + "\n\n")
+}
+
+func (markdownRenderer) startFlow(buf *bytes.Buffer, f *sourcePart, doc string) {
+	buf.WriteString(fmt.Sprintf(flowStart, f.name, filepath.Base(f.goFile), f.start, f.end))
+	buf.WriteString(doc + "\n")
+}
+
+func (r markdownRenderer) diagram(buf *bytes.Buffer, f *sourcePart, dsl string, svg []byte) error {
+	if r.diagramType == DiagramMermaid {
+		flow, err := parseFlowDSL(dsl, f.name)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("```mermaid\n" + flowToMermaid(newFlowGraph(flow)) + "```\n\n")
+		return nil
+	}
+	switch r.embed {
+	case EmbedInline:
+		buf.Write(removeEmptyLines(stripXMLHeader(svg)))
+		buf.WriteString("\n")
+	case EmbedDataURI:
+		buf.WriteString(fmt.Sprintf("![Flow: %s](data:image/svg+xml;base64,%s)\n\n",
+			f.name, base64.StdEncoding.EncodeToString(svg)))
+	default:
+		if err := f.mdFile.fImps.packDict.writeFile(
+			filepath.Join(filepath.Dir(f.mdFile.name), f.name+".svg"), svg); err != nil {
+			return err
+		}
+		buf.WriteString(fmt.Sprintf("![Flow: %s](./%s.svg)\n\n", f.name, f.name))
+	}
+	return nil
+}
+
+func (markdownRenderer) references(buf *bytes.Buffer, compLinks, dataLinks []link) {
+	buf.WriteString(referenceTableHeader)
+	for i := 0; i < len(compLinks) || i < len(dataLinks); i++ {
+		if i < len(compLinks) {
+			buf.WriteString(mdLink(compLinks[i]))
+		}
+		buf.WriteString(" | ")
+		if i < len(dataLinks) {
+			buf.WriteString(mdLink(dataLinks[i]))
+		}
+		buf.WriteRune('\n')
+	}
+	buf.WriteString("\n")
+}
+
+func (markdownRenderer) portReferences(buf *bytes.Buffer, refs []compRefs) {
+	buf.WriteString(portRefsTableHeader)
+	for _, r := range refs {
+		buf.WriteString(mdLink(r.comp) + " | " + strings.Join(formatPortRefs(r.ins, mdLink), "<br>") + " | ")
+		buf.WriteString(strings.Join(formatPortRefs(r.outs, mdLink), "<br>") + "\n")
+	}
+	buf.WriteString("\n")
+}
+
+func (markdownRenderer) signatures(buf *bytes.Buffer, sigLinks []link) {
+	buf.WriteString(signatureTableHeader)
+	for _, l := range sigLinks {
+		sig := l.part.sig
+		buf.WriteString(mdLink(l) + " | `" + sig.input() + "` | ")
+		buf.WriteString(mdCodeList(sig.output()) + " | " + mdCodeList(sig.plugins) + " | ")
+		buf.WriteString(strings.ReplaceAll(sig.summary, "|", `\|`) + "\n")
+	}
+	buf.WriteString("\n")
+}
+
+func mdCodeList(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return "`" + strings.Join(list, "`, `") + "`"
+}
+
+func (markdownRenderer) dataTypes(buf *bytes.Buffer, types []typeEntry) {
+	for _, e := range types {
+		def := e.def
+		buf.WriteString("Data type " + mdLink(e.link) + ": ")
+		if def.kind == "" {
+			buf.WriteString(mdTypeExpr(e, def.underlying))
+		} else {
+			buf.WriteString("`" + def.kind + "`")
+		}
+		buf.WriteString("\n\n")
+		if def.summary != "" {
+			buf.WriteString(def.summary + "\n\n")
+		}
+		if len(def.members) == 0 {
+			continue
+		}
+		if def.kind == typeKindInterface {
+			buf.WriteString(dataMethodsHeader)
+		} else {
+			buf.WriteString(dataFieldsHeader)
+		}
+		for _, m := range def.members {
+			name := "*embedded*"
+			if m.name != "" {
+				name = "`" + m.name + "`"
+			}
+			buf.WriteString(name + " | " + strings.ReplaceAll(mdTypeExpr(e, m.typ), "|", `\|`) + " | ")
+			buf.WriteString(strings.ReplaceAll(m.doc, "|", `\|`) + "\n")
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// mdTypeExpr links the type expression if it references exactly one known
+// type and lists the links after it otherwise.
+func mdTypeExpr(e typeEntry, te typeExpr) string {
+	code := "`" + te.expr + "`"
+	links := e.refLinks(te)
+	if len(links) == 0 {
+		return code
+	}
+	if len(links) == 1 {
+		return "[" + code + "](" + links[0].url + ")"
+	}
+	names := make([]string, len(links))
+	for i, l := range links {
+		names[i] = mdLink(l)
+	}
+	return code + " (" + strings.Join(names, ", ") + ")"
+}
+
+func (markdownRenderer) endFlow(buf *bytes.Buffer, doc string) {
+	buf.WriteString(doc)
+}
+
+func (markdownRenderer) flowIndex(buf *bytes.Buffer, flowLinks []link) {
+	if len(flowLinks) == 0 {
+		return
+	}
+	buf.WriteString(mdFlowIndexStart)
+	for _, l := range flowLinks {
+		buf.WriteString("- " + mdLink(l) + "\n")
+	}
+}
+
+func (markdownRenderer) endFile(buf *bytes.Buffer) {
+}
+
+func mdLink(l link) string {
+	if l.url == "" {
+		return l.name
+	}
+	return "[" + l.name + "](" + l.url + ")"
+}
+
+// removeEmptyLines removes all empty lines because they would end an HTML
+// block in Markdown.
+func removeEmptyLines(b []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	result := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) > 0 {
+			result = append(result, line)
+		}
+	}
+	return append(bytes.Join(result, []byte("\n")), '\n')
+}
@@ -0,0 +1,34 @@
+package goast
+
+import (
+	"strings"
+)
+
+// flowToMermaid converts a flow graph into a Mermaid flowchart.
+func flowToMermaid(g *flowGraph) string {
+	b := strings.Builder{}
+	b.WriteString("flowchart LR\n")
+	for _, n := range g.nodes {
+		label := mermaidText(strings.Join(n.label, "\n"))
+		b.WriteString("    " + n.id)
+		if n.kind == flowNodeComponent {
+			b.WriteString(`["` + label + `"]` + "\n")
+		} else {
+			b.WriteString(`(("` + label + `"))` + "\n")
+		}
+	}
+	for _, e := range g.edges {
+		b.WriteString("    " + e.from.id + " -->")
+		if l := e.label(); l != "" {
+			b.WriteString(`|"` + mermaidText(l) + `"|`)
+		}
+		b.WriteString(" " + e.to.id + "\n")
+	}
+	return b.String()
+}
+
+// mermaidText escapes text so it can be used inside of quotes in Mermaid.
+func mermaidText(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
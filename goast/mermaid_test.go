@@ -0,0 +1,58 @@
+package goast
+
+import (
+	"testing"
+)
+
+func TestFlowToMermaid(t *testing.T) {
+	specs := []struct {
+		name     string
+		givenDSL string
+		expected string
+	}{
+		{
+			name:     "simple",
+			givenDSL: "in (data)-> [a] -> out\n",
+			expected: "flowchart LR\n" +
+				"    in_in((\"in\"))\n" +
+				"    comp_a[\"a\"]\n" +
+				"    out_out((\"out\"))\n" +
+				"    in_in -->|\"(data)\"| comp_a\n" +
+				"    comp_a --> out_out\n",
+		}, {
+			name: "ports-and-types",
+			givenDSL: "in (data)-> myIn [c1 pack.Comp] out (a, b | c)-> arrayIn:1 [c2]\n" +
+				"[c1] error (error)-> error\n",
+			expected: "flowchart LR\n" +
+				"    in_in((\"in\"))\n" +
+				"    comp_c1[\"c1<br>pack.Comp\"]\n" +
+				"    comp_c2[\"c2\"]\n" +
+				"    out_error((\"error\"))\n" +
+				"    in_in -->|\"(data) myIn\"| comp_c1\n" +
+				"    comp_c1 -->|\"out (a, b | c) arrayIn[1]\"| comp_c2\n" +
+				"    comp_c1 -->|\"error (error)\"| out_error\n",
+		}, {
+			name: "continuation-and-plugin",
+			givenDSL: "in (data)-> [a [plug = B, c.D]] -> ...1\n" +
+				"...1 (data)-> [e]\n",
+			expected: "flowchart LR\n" +
+				"    in_in((\"in\"))\n" +
+				"    comp_a[\"a<br>plug = B, c.D\"]\n" +
+				"    comp_e[\"e\"]\n" +
+				"    in_in -->|\"(data)\"| comp_a\n" +
+				"    comp_a -->|\"(data)\"| comp_e\n",
+		},
+	}
+	for _, spec := range specs {
+		t.Logf("Testing flow: %s\n", spec.name)
+		flow, err := parseFlowDSL(spec.givenDSL, spec.name)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			continue
+		}
+		got := flowToMermaid(newFlowGraph(flow))
+		if spec.expected != got {
+			t.Errorf("Expected Mermaid:\n%s\ngot:\n%s", spec.expected, got)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package goast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// ModelVersion is the version of the schema of the machine-readable model.
+// It is incremented with every incompatible change.
+const ModelVersion = 1
+
+// modelFileName is the base name of the file the model is written to.
+const modelFileName = "flows"
+
+// Model is the machine-readable representation of all processed packages
+// with their flows.
+// All paths are relative to the project root and use slashes.
+// Only paths outside of the project are absolute.
+type Model struct {
+	Version  int             `json:"version"`
+	Packages []*ModelPackage `json:"packages"`
+}
+
+// ModelPackage is a Go package containing flows.
+type ModelPackage struct {
+	Name  string       `json:"name"`
+	Dir   string       `json:"dir"`
+	Files []*ModelFile `json:"files"`
+}
+
+// ModelFile is a Go source file containing flows.
+type ModelFile struct {
+	Name  string       `json:"name"`
+	Flows []*ModelFlow `json:"flows"`
+}
+
+// ModelFlow is a single flow with its documentation and references.
+type ModelFlow struct {
+	Name       string      `json:"name"`
+	Start      int         `json:"start"`
+	End        int         `json:"end"`
+	DocStart   string      `json:"docStart,omitempty"`
+	DSL        string      `json:"dsl"`
+	DocEnd     string      `json:"docEnd,omitempty"`
+	Components []*ModelRef `json:"components"`
+	DataTypes  []*ModelRef `json:"dataTypes"`
+}
+
+// ModelRef is a reference from a flow to a component or data type.
+// The URL always points to the source code of the target.
+// The target fields are empty if the reference couldn't be resolved.
+type ModelRef struct {
+	Name    string `json:"name"`
+	Package string `json:"package,omitempty"`
+	Kind    string `json:"kind,omitempty"` // flow, func or type
+	URL     string `json:"url,omitempty"`
+	File    string `json:"file,omitempty"`
+	Start   int    `json:"start,omitempty"`
+	End     int    `json:"end,omitempty"`
+}
+
+// addToModel adds a package with all its flows to the model.
+func addToModel(
+	model *Model, pkgName, pkgDir string,
+	flows []*sourcePart, convs []*convertedFlow,
+	fileMap map[string]*mdFile,
+	partMap map[string]*sourcePart,
+	packDict *packageDict,
+) error {
+	mPack := &ModelPackage{Name: pkgName, Dir: modelPath(pkgDir, packDict)}
+	mFiles := make(map[string]*ModelFile)
+	for i, f := range flows {
+		file := fileMap[f.mdFile.name]
+		if file == nil {
+			return fmt.Errorf("missing flow file: " + f.mdFile.name)
+		}
+		f.mdFile = file
+		mFlow, err := flowToModel(f, convs[i], partMap)
+		if err != nil {
+			return err
+		}
+		mFile := mFiles[f.goFile]
+		if mFile == nil {
+			mFile = &ModelFile{Name: modelPath(f.goFile, packDict)}
+			mFiles[f.goFile] = mFile
+			mPack.Files = append(mPack.Files, mFile)
+		}
+		mFile.Flows = append(mFile.Flows, mFlow)
+	}
+	sort.Slice(mPack.Files, func(i, j int) bool {
+		return mPack.Files[i].Name < mPack.Files[j].Name
+	})
+	for _, mFile := range mPack.Files {
+		sort.Slice(mFile.Flows, func(i, j int) bool {
+			return mFile.Flows[i].Start < mFile.Flows[j].Start
+		})
+	}
+	packDict.mutex.Lock()
+	model.Packages = append(model.Packages, mPack)
+	packDict.mutex.Unlock()
+	return nil
+}
+func flowToModel(f *sourcePart, conv *convertedFlow, partMap map[string]*sourcePart) (*ModelFlow, error) {
+	fmt.Println("processing flow:", f.name)
+	start, flow, end := f.docStart, f.dsl, f.docEnd
+	if conv.err != nil {
+		return nil, conv.err
+	}
+	compTypes, dataTypes := conv.compTypes, conv.dataTypes
+	packDict := f.mdFile.fImps.packDict
+	mFlow := &ModelFlow{
+		Name:       f.name,
+		Start:      f.start,
+		End:        f.end,
+		DocStart:   start,
+		DSL:        flow,
+		DocEnd:     end,
+		Components: make([]*ModelRef, 0, len(compTypes)),
+		DataTypes:  make([]*ModelRef, 0, len(dataTypes)),
+	}
+	for _, comp := range sortTypes(compTypes) {
+		mFlow.Components = append(mFlow.Components,
+			linkToModel(comp, getSourceLinkForComponent(comp, partMap, f.mdFile), packDict))
+	}
+	for _, typ := range sortTypes(filterTypes(dataTypes)) {
+		mFlow.DataTypes = append(mFlow.DataTypes,
+			linkToModel(typ, getLinkForType(typ, partMap, f.mdFile), packDict))
+	}
+	return mFlow, nil
+}
+func linkToModel(typ data.Type, l link, packDict *packageDict) *ModelRef {
+	ref := &ModelRef{Name: typ.LocalType, Package: typ.Package, URL: l.url}
+	if l.part == nil {
+		return ref
+	}
+	switch l.part.kind {
+	case sourcePartFlow:
+		ref.Kind = "flow"
+	case sourcePartFunc:
+		ref.Kind = "func"
+	case sourcePartType:
+		ref.Kind = "type"
+	}
+	ref.File = modelPath(l.part.goFile, packDict)
+	ref.Start = l.part.start
+	ref.End = l.part.end
+	return ref
+}
+
+// modelPath returns the path relative to the project root if it is inside
+// of the project and the absolute path otherwise.
+// Relative paths are relative to the working directory.
+func modelPath(name string, packDict *packageDict) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(packDict.cwd, name)
+	}
+	rel, err := filepath.Rel(packDict.projRoot, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(name)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// sortModel sorts the packages of the model by directory and name.
+// So the model doesn't depend on the order of processing.
+func sortModel(model *Model) {
+	sort.Slice(model.Packages, func(i, j int) bool {
+		pi, pj := model.Packages[i], model.Packages[j]
+		if pi.Dir == pj.Dir {
+			return pi.Name < pj.Name
+		}
+		return pi.Dir < pj.Dir
+	})
+}
+
+// writeModel writes the model in the requested format to the current
+// directory.
+func writeModel(model *Model, format string, packDict *packageDict) error {
+	var buf []byte
+	var err error
+	if format == FormatYAML {
+		buf, err = toYAML(model)
+	} else {
+		buf, err = marshalJSON(model, "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return packDict.writeFile(filepath.Join(packDict.cwd, modelFileName+"."+format), buf)
+}
+
+// marshalJSON works like json.MarshalIndent but doesn't escape HTML
+// characters like '<' and '>' that are common in flows.
+// The result always ends with a new line.
+func marshalJSON(v interface{}, indent string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,177 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const manifestFileName = ".go2md-manifest"
+
+// output writes all generated files of one directory.
+// It remembers them in the manifest of that directory, so it always knows
+// which files are owned by go2md.
+// It is safe for concurrent use.
+type output struct {
+	mutex    sync.Mutex
+	dir      string
+	cwd      string
+	manifest string
+	dryRun   bool
+	owned    []string // generated files according to the manifest
+	written  map[string]bool
+	mem      *memFiles // only set if the files are kept in memory
+}
+
+// newOutput creates the output for the given directory and reads the
+// manifest file of the last run.
+// All file names are relative to the directory.
+func newOutput(dir, cwd string, dryRun bool) *output {
+	o := &output{
+		dir:      dir,
+		cwd:      cwd,
+		manifest: filepath.Join(dir, manifestFileName),
+		dryRun:   dryRun,
+		written:  make(map[string]bool),
+	}
+	buf, err := ioutil.ReadFile(o.manifest)
+	if err != nil {
+		return o
+	}
+	for _, name := range strings.Split(string(buf), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			o.owned = append(o.owned, name)
+		}
+	}
+	return o
+}
+
+// writeFile writes a generated file only if its content has changed.
+// So the timestamps of unchanged files stay untouched.
+func (o *output) writeFile(name string, content []byte) error {
+	o.mutex.Lock()
+	o.written[filepath.Base(name)] = true
+	o.mutex.Unlock()
+	if o.mem != nil {
+		o.mem.put(name, content)
+		return nil
+	}
+	old, err := ioutil.ReadFile(name)
+	if err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if o.dryRun {
+		fmt.Println("Would write:", o.displayName(name))
+		return nil
+	}
+	return ioutil.WriteFile(name, content, os.FileMode(0666))
+}
+
+// displayName returns the file name relative to the working directory if
+// possible.
+func (o *output) displayName(name string) string {
+	if rel, err := filepath.Rel(o.cwd, name); err == nil {
+		return rel
+	}
+	return name
+}
+
+// finish removes stale generated files if requested and writes the
+// manifest.
+// Stale files are files of the last run that haven't been written by this
+// run. They stay in the manifest until they are removed.
+func (o *output) finish(clean bool) error {
+	if o.mem != nil {
+		o.mem.removeStale(o.dir, o.written)
+		return nil
+	}
+	owned := make([]string, 0, len(o.written)+len(o.owned))
+	for name := range o.written {
+		owned = append(owned, name)
+	}
+	for _, name := range o.owned {
+		if o.written[name] {
+			continue
+		}
+		file := filepath.Join(o.dir, name)
+		if _, err := os.Stat(file); err != nil {
+			continue // already gone
+		}
+		if !clean {
+			owned = append(owned, name)
+			continue
+		}
+		if o.dryRun {
+			fmt.Println("Would remove:", o.displayName(file))
+			continue
+		}
+		fmt.Println("Removing stale file:", o.displayName(file))
+		if err := os.Remove(file); err != nil {
+			log.Printf("WARNING: Unable to remove stale file '%s': %v", file, err)
+			owned = append(owned, name)
+		}
+	}
+	if o.dryRun {
+		return nil
+	}
+	if len(owned) == 0 {
+		if err := os.Remove(o.manifest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	sort.Strings(owned)
+	return ioutil.WriteFile(o.manifest, []byte(strings.Join(owned, "\n")+"\n"), os.FileMode(0666))
+}
+
+// memFiles keeps generated files in memory instead of writing them.
+// It is safe for concurrent use.
+type memFiles struct {
+	mutex sync.Mutex
+	files map[string][]byte // by absolute file name
+}
+
+func newMemFiles() *memFiles {
+	return &memFiles{files: make(map[string][]byte)}
+}
+
+func (m *memFiles) put(name string, content []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[name] = content
+}
+func (m *memFiles) get(name string) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	content, ok := m.files[name]
+	return content, ok
+}
+
+// removeStale removes all files of the directory that haven't been written.
+func (m *memFiles) removeStale(dir string, written map[string]bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for name := range m.files {
+		if filepath.Dir(name) == dir && !written[filepath.Base(name)] {
+			delete(m.files, name)
+		}
+	}
+}
+
+// names returns the names of all files sorted.
+func (m *memFiles) names() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
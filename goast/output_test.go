@@ -0,0 +1,160 @@
+package goast_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestStaleFiles(t *testing.T) {
+	const src = `package x
+
+// Foo does foo.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func Foo(i int) int { return bar(i) }
+
+// Baz does baz.
+//
+// flow:
+//     in (int)-> [bar] -> out
+func Baz(i int) int { return bar(i) }
+
+func bar(i int) int { return i }
+`
+	userFiles := []string{"Old.svg", "notes.md"} // not generated by go2md
+	specs := []struct {
+		name            string
+		givenFirst      goast.Options
+		givenSecond     goast.Options
+		expectedKept    []string
+		expectedRemoved []string
+		expectedOutput  []string
+	}{
+		{
+			name:            "default",
+			givenFirst:      goast.Options{},
+			givenSecond:     goast.Options{},
+			expectedKept:    []string{"Baz.svg", "Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{},
+		}, {
+			name:            "clean",
+			givenFirst:      goast.Options{Clean: true},
+			givenSecond:     goast.Options{Clean: true},
+			expectedKept:    []string{"Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{"Baz.svg"},
+		}, {
+			name:            "cache",
+			givenFirst:      goast.Options{Cache: true},
+			givenSecond:     goast.Options{Cache: true},
+			expectedKept:    []string{"Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{"Baz.svg"},
+		}, {
+			name:            "dry-run",
+			givenFirst:      goast.Options{Clean: true},
+			givenSecond:     goast.Options{Clean: true, DryRun: true},
+			expectedKept:    []string{"Baz.svg", "Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{},
+			expectedOutput:  []string{"Would write: x.md", "Would remove: Baz.svg"},
+		}, {
+			name:            "dry-run-after-default",
+			givenFirst:      goast.Options{},
+			givenSecond:     goast.Options{Clean: true, DryRun: true},
+			expectedKept:    []string{"Baz.svg", "Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{},
+			expectedOutput:  []string{"Would remove: Baz.svg"},
+		}, {
+			name:            "clean-after-default",
+			givenFirst:      goast.Options{},
+			givenSecond:     goast.Options{Clean: true},
+			expectedKept:    []string{"Foo.svg", "x.md", ".go2md-manifest"},
+			expectedRemoved: []string{"Baz.svg"},
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range userFiles {
+				writeTestFile(t, filepath.Join(dir, name), "user content")
+			}
+			writeTestFile(t, filepath.Join(dir, "x.go"), src)
+			if err := runGo2md(t, dir, spec.givenFirst); err != nil {
+				t.Fatalf("Unable to process directory: %v", err)
+			}
+
+			withoutBaz := strings.Replace(src, "// flow:\n//     in (int)-> [bar] -> out\nfunc Baz", "func Baz", 1)
+			writeTestFile(t, filepath.Join(dir, "x.go"), withoutBaz)
+			output := captureStdout(t, func() {
+				if err := runGo2md(t, dir, spec.givenSecond); err != nil {
+					t.Fatalf("Unable to process directory: %v", err)
+				}
+			})
+			for _, line := range spec.expectedOutput {
+				if !strings.Contains(output, "\n"+line+"\n") {
+					t.Errorf("Expected the line %q in the output:\n%s", line, output)
+				}
+			}
+
+			for _, name := range append(spec.expectedKept, userFiles...) {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("Expected file %q to exist but got: %v", name, err)
+				}
+			}
+			for _, name := range spec.expectedRemoved {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("Expected file %q to be removed but got: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+// runGo2md processes the directory like go2md would do.
+func runGo2md(t *testing.T, dir string, opts goast.Options) error {
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to get working directory: %v", err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatalf("Unable to change to temporary directory: %v", err)
+	}
+	defer os.Chdir(oldDir)
+
+	packDict, err := goast.NewPackageDict(nil, dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to create package dictionary: %v", err)
+	}
+	return goast.ProcessDir(".", packDict)
+}
+
+// captureStdout returns everything that is printed to stdout by f.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unable to create pipe: %v", err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	outC := make(chan string)
+	go func() {
+		buf, _ := ioutil.ReadAll(r)
+		outC <- string(buf)
+	}()
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+	f()
+	w.Close()
+	return "\n" + <-outC
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	if err := ioutil.WriteFile(name, []byte(content), os.FileMode(0666)); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+}
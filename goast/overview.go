@@ -0,0 +1,271 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flowdev/gflowparser"
+	"github.com/flowdev/gflowparser/data"
+)
+
+const (
+	overviewFileName = "flow-overview"
+	overviewStart    = "# Flow Overview\n\n"
+	overviewTable    = `Flow | Uses
+---- | ----
+`
+)
+
+// overview collects the usage of flows and functions by all processed
+// flows to create a project-wide call graph.
+// It is safe for concurrent use.
+type overview struct {
+	mutex sync.Mutex
+	nodes map[string]*overviewNode
+}
+
+// overviewNode is a flow, function or undefined component in the overview.
+type overviewNode struct {
+	key       string
+	name      string
+	link      link
+	processed bool // processed flow (not just referenced)
+	usedBy    int
+	uses      map[string]*overviewNode
+	dslName   string
+}
+
+func newOverview() *overview {
+	return &overview{nodes: make(map[string]*overviewNode)}
+}
+
+// addFlow adds a processed flow and all of its components to the overview.
+func (ov *overview) addFlow(f *sourcePart, compTypes []data.Type, partMap map[string]*sourcePart) {
+	cwd := f.mdFile.fImps.packDict.cwd
+	ovFile := &mdFile{name: filepath.Join(cwd, overviewFileName), fImps: f.mdFile.fImps}
+	fileName, err := fileNameFor(f, markerFlow, ovFile)
+	if err != nil {
+		fmt.Println("WARNING: Unable to compute correct URL for flow", f.name, ":", err)
+		fileName = f.mdFile.name + f.mdFile.fImps.packDict.render.fileExt()
+	}
+	ov.mutex.Lock()
+	defer ov.mutex.Unlock()
+	fn := ov.nodeFor(partKey(f, cwd), f.name,
+		link{name: f.name, url: fileName + "#flow-" + strings.ToLower(f.name), part: f})
+	fn.processed = true
+
+	for _, comp := range compTypes {
+		l := getLinkForComponent(comp, partMap, ovFile)
+		key := "?" + l.name // undefined components are identified by name
+		if l.part != nil {
+			key = partKey(l.part, cwd)
+		}
+		cn := ov.nodeFor(key, l.name, l)
+		if fn.uses[key] == nil {
+			fn.uses[key] = cn
+			cn.usedBy++
+		}
+	}
+}
+func (ov *overview) nodeFor(key, name string, l link) *overviewNode {
+	n := ov.nodes[key]
+	if n == nil {
+		n = &overviewNode{key: key, name: name, link: l, uses: make(map[string]*overviewNode)}
+		ov.nodes[key] = n
+	}
+	return n
+}
+func partKey(p *sourcePart, cwd string) string {
+	file := p.goFile
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(cwd, file)
+	}
+	return file + "#" + p.name
+}
+
+// sortedNodes returns the given nodes sorted by name and key.
+func sortedNodes(nodeMap map[string]*overviewNode) []*overviewNode {
+	nodes := make([]*overviewNode, 0, len(nodeMap))
+	for _, n := range nodeMap {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].name == nodes[j].name {
+			return nodes[i].key < nodes[j].key
+		}
+		return nodes[i].name < nodes[j].name
+	})
+	return nodes
+}
+
+// writeOverview writes the overview document and diagram into the current
+// directory.
+func writeOverview(ov *overview, diagramType string, packDict *packageDict) error {
+	nodes := sortedNodes(ov.nodes)
+	if len(nodes) == 0 {
+		return nil
+	}
+	entries := make([]*overviewNode, 0, len(nodes))
+	undefined := make([]*overviewNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n.processed && n.usedBy == 0 {
+			entries = append(entries, n)
+		}
+		if n.link.part == nil {
+			undefined = append(undefined, n)
+		}
+	}
+	assignDSLNames(nodes)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(overviewStart)
+	if diagramType == DiagramMermaid {
+		buf.WriteString("```mermaid\n" + overviewToMermaid(nodes, entries, undefined) + "```\n\n")
+	} else {
+		svg, info, err := convertOverviewDSL(overviewToDSL(nodes, entries))
+		if err != nil {
+			return err
+		}
+		if info != "" {
+			log.Printf("INFO: %s", info)
+		}
+		if err = packDict.writeFile(filepath.Join(packDict.cwd, overviewFileName+".svg"), svg); err != nil {
+			return err
+		}
+		buf.WriteString(fmt.Sprintf("![Flow Overview](./%s.svg)\n\n", overviewFileName))
+	}
+
+	buf.WriteString("## Entry Points\n\n")
+	writeOverviewList(buf, entries)
+	if len(undefined) > 0 {
+		buf.WriteString("## Undefined Components\n\n")
+		writeOverviewList(buf, undefined)
+	}
+	buf.WriteString("## Usage\n\n" + overviewTable)
+	for _, n := range nodes {
+		if !n.processed {
+			continue
+		}
+		buf.WriteString(mdLink(n.link) + " | ")
+		for i, u := range sortedNodes(n.uses) {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(mdLink(u.link))
+		}
+		buf.WriteString("\n")
+	}
+	return packDict.writeFile(filepath.Join(packDict.cwd, overviewFileName+".md"), buf.Bytes())
+}
+func writeOverviewList(buf *bytes.Buffer, nodes []*overviewNode) {
+	for _, n := range nodes {
+		buf.WriteString("- " + mdLink(n.link) + "\n")
+	}
+	buf.WriteString("\n")
+}
+
+// assignDSLNames gives every node a unique name that is valid in the flow
+// DSL.
+func assignDSLNames(nodes []*overviewNode) {
+	used := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		local := n.name[strings.LastIndex(n.name, ".")+1:]
+		name := strings.ToLower(local[:1]) + local[1:]
+		for i := 2; used[name]; i++ {
+			name = strings.ToLower(local[:1]) + local[1:] + strconv.Itoa(i)
+		}
+		used[name] = true
+		n.dslName = name
+	}
+}
+
+// overviewToDSL creates a flow DSL that can be converted to SVG.
+// Entry points get an input port 'entry' and undefined components get the
+// input port 'undefined'.
+// The SVG converter requires every component with incoming arrows to be
+// reached by one before it starts a line, so the nodes are visited breadth
+// first from the entry points.
+// Flows that are only reachable by recursion get the input port 'recursive'.
+func overviewToDSL(nodes, entries []*overviewNode) string {
+	declared := make(map[string]bool, len(nodes))
+	decl := func(n *overviewNode) string {
+		if declared[n.key] {
+			return "[" + n.dslName + "]"
+		}
+		declared[n.key] = true
+		return "[" + n.dslName + " " + n.name + "]"
+	}
+	b := strings.Builder{}
+	queue := make([]*overviewNode, 0, len(nodes))
+	visit := func(port string, n *overviewNode) {
+		b.WriteString(port + " -> " + decl(n) + "\n")
+		queue = append(queue, n)
+	}
+	for _, n := range entries {
+		visit("entry", n)
+	}
+	for i := 0; i < len(nodes) || len(queue) > 0; {
+		if len(queue) == 0 {
+			if n := nodes[i]; !declared[n.key] && len(n.uses) > 0 {
+				visit("recursive", n)
+			}
+			i++
+			continue
+		}
+		n := queue[0]
+		queue = queue[1:]
+		for _, u := range sortedNodes(n.uses) {
+			b.WriteString(decl(n) + " -> ")
+			if u.link.part == nil {
+				b.WriteString("undefined ")
+			}
+			if !declared[u.key] {
+				queue = append(queue, u)
+			}
+			b.WriteString(decl(u) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// convertOverviewDSL converts the overview DSL to SVG.
+// Panics of the converter are returned as errors.
+func convertOverviewDSL(dsl string) (svg []byte, info string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to convert the flow overview to SVG: %v", r)
+		}
+	}()
+	svg, _, _, info, err = gflowparser.ConvertFlowDSLToSVG(dsl, overviewFileName)
+	return svg, info, err
+}
+
+// overviewToMermaid creates a Mermaid flowchart with entry points and
+// undefined components highlighted.
+func overviewToMermaid(nodes, entries, undefined []*overviewNode) string {
+	b := strings.Builder{}
+	b.WriteString("flowchart LR\n")
+	b.WriteString("    classDef entry fill:#9f9,stroke:#333\n")
+	b.WriteString("    classDef undefined fill:#f99,stroke:#333,stroke-dasharray: 5 5\n")
+	for _, n := range nodes {
+		b.WriteString(`    n_` + n.dslName + `["` + mermaidText(n.name) + `"]` + "\n")
+	}
+	for _, n := range nodes {
+		for _, u := range sortedNodes(n.uses) {
+			b.WriteString("    n_" + n.dslName + " --> n_" + u.dslName + "\n")
+		}
+	}
+	for _, n := range entries {
+		b.WriteString("    class n_" + n.dslName + " entry\n")
+	}
+	for _, n := range undefined {
+		b.WriteString("    class n_" + n.dslName + " undefined\n")
+	}
+	return b.String()
+}
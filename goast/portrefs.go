@@ -0,0 +1,110 @@
+package goast
+
+import (
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// compRefs are the data types that a component receives and emits per port.
+type compRefs struct {
+	comp link
+	ins  []portRefs
+	outs []portRefs
+}
+
+// portRefs are the data types of one port.
+// Links of unknown types only contain the name.
+type portRefs struct {
+	port  string
+	types []link
+}
+
+// portReferences collects the data types per port of all components of
+// the graph in the order of their appearance.
+// Only data types given on the arrows are used because the inherited ones
+// are just a guess.
+func portReferences(f *sourcePart, g *flowGraph, partMap map[string]*sourcePart) []compRefs {
+	refs := make([]compRefs, 0, len(g.nodes))
+	index := make(map[*flowNode]int)
+	for _, n := range g.nodes {
+		if n.kind == flowNodeComponent {
+			index[n] = len(refs)
+			refs = append(refs, compRefs{comp: getLinkForComponent(n.comp, partMap, f.mdFile)})
+		}
+	}
+	for _, e := range g.edges {
+		types := make([]link, 0, len(e.types))
+		for _, t := range e.types {
+			if !t.Separator() {
+				types = append(types, portTypeLink(t, partMap, f.mdFile))
+			}
+		}
+		if !e.explicit {
+			types = types[:0]
+		}
+		if i, ok := index[e.to]; ok {
+			refs[i].ins = addPortRefs(refs[i].ins, portOrDefault(e.toPort, "in"), types)
+		}
+		if i, ok := index[e.from]; ok {
+			refs[i].outs = addPortRefs(refs[i].outs, portOrDefault(e.fromPort, "out"), types)
+		}
+	}
+	return refs
+}
+
+// portTypeLink links the type or the element type of a slice.
+func portTypeLink(t data.Type, partMap map[string]*sourcePart, mdFile *mdFile) link {
+	name := typeToString(t)
+	t.LocalType = strings.TrimPrefix(t.LocalType, "[]")
+	l := getLinkForType(t, partMap, mdFile)
+	l.name = name
+	return l
+}
+
+func portOrDefault(port, def string) string {
+	if port == "" {
+		return def
+	}
+	return port
+}
+
+func addPortRefs(ports []portRefs, port string, types []link) []portRefs {
+	i := 0
+	for i < len(ports) && ports[i].port != port {
+		i++
+	}
+	if i == len(ports) {
+		ports = append(ports, portRefs{port: port})
+	}
+	for _, t := range types {
+		if !containsLink(ports[i].types, t) {
+			ports[i].types = append(ports[i].types, t)
+		}
+	}
+	return ports
+}
+func containsLink(links []link, l link) bool {
+	for _, l2 := range links {
+		if l2.name == l.name {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPortRefs formats the ports like in the flow DSL: 'in (A, B)'.
+func formatPortRefs(ports []portRefs, linkFn func(link) string) []string {
+	result := make([]string, len(ports))
+	for i, p := range ports {
+		result[i] = p.port
+		if len(p.types) > 0 {
+			types := make([]string, len(p.types))
+			for j, t := range p.types {
+				types[j] = linkFn(t)
+			}
+			result[i] += " (" + strings.Join(types, ", ") + ")"
+		}
+	}
+	return result
+}
@@ -0,0 +1,479 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// scaffoldComp is a component of a flow that is missing in the Go code.
+type scaffoldComp struct {
+	name    string
+	flow    string
+	ins     []scaffoldPort
+	outs    []scaffoldPort
+	plugins []data.Plugin
+}
+
+// scaffoldPort is a port of a component with the data types of the first
+// arrow connected to it.
+type scaffoldPort struct {
+	name  string
+	types []data.Type
+}
+
+// ScaffoldDirs generates Go function stubs for all components of the flows
+// in the given directories that don't exist yet.
+// The signatures are derived from the data types on the arrows and follow
+// the rules in RULES.md: multiple input ports become functions named
+// 'ComponentPortXxx', multiple output ports become return values named
+// 'portXxx' and an 'err' port becomes a trailing error.
+// Existing functions are never touched.
+func ScaffoldDirs(dirs []string, dryRun bool) error {
+	for _, dir := range dirs {
+		if err := scaffoldDir(dir, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func scaffoldDir(dir string, dryRun bool) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, excludeTests, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("unable to parse the directory '%s': %w", dir, err)
+	}
+	for _, pkg := range sortedPackages(pkgs) {
+		if isTestPackage(pkg.Name) {
+			continue
+		}
+		if err = scaffoldPackage(pkg, dir, fset, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scaffoldPackage(pkg *ast.Package, dir string, fset *token.FileSet, dryRun bool) error {
+	partMap := make(map[string]*sourcePart)
+	flows := make([]*sourcePart, 0, 32)
+	names := make(map[string]bool)          // all declared names of the package
+	funcs := make(map[string]*ast.FuncDecl) // for plugin signatures
+	imports := make(map[string]string)      // import specs of the package by local name
+	var err error
+	for _, name := range sortedFileNames(pkg.Files) {
+		astf := pkg.Files[name]
+		if flows, err = findSourceParts(partMap, flows, astf, name, "", fset); err != nil {
+			return fmt.Errorf("unable to find all flows in package (%s): %w", pkg.Name, err)
+		}
+		addDeclaredNames(astf, names, funcs)
+		for _, imp := range astf.Imports {
+			if name := importName(imp); name != "" && imports[name] == "" {
+				imports[name] = imp.Path.Value
+				if imp.Name != nil {
+					imports[name] = imp.Name.Name + " " + imp.Path.Value
+				}
+			}
+		}
+	}
+	if flows, err = findFlowFiles(partMap, flows, dir, ""); err != nil {
+		return fmt.Errorf("unable to find all flow files of package (%s): %w", pkg.Name, err)
+	}
+
+	files := make([]string, 0, 4)
+	stubs := make(map[string][]*scaffoldComp)
+	for _, f := range flows {
+		pFlow, err := parseFlowDSL(f.dsl, f.name)
+		if err != nil {
+			log.Printf("WARNING: Unable to scaffold flow %s: %v", f.name, err)
+			continue
+		}
+		file := f.goFile
+		if !strings.HasSuffix(file, ".go") { // flow file without Go function
+			file = goNameToBase(file) + ".go"
+		}
+		for _, c := range missingComponents(f, newFlowGraph(pFlow), names) {
+			if stubs[file] == nil {
+				files = append(files, file)
+			}
+			stubs[file] = append(stubs[file], c)
+		}
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		fnames := make([]string, 0, len(stubs[file]))
+		buf := &bytes.Buffer{}
+		for _, c := range stubs[file] {
+			fnames = append(fnames, writeStubs(buf, c, funcs, fset)...)
+		}
+		if dryRun {
+			fmt.Printf("Would scaffold in %s: %s\n", file, strings.Join(fnames, ", "))
+			continue
+		}
+		fmt.Printf("Scaffolding in %s: %s\n", file, strings.Join(fnames, ", "))
+		src, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			src, err = []byte("package "+pkg.Name+"\n"), nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read Go file: %w", err)
+		}
+		specs, err := stubImports(src, buf.Bytes(), imports, dir)
+		if err != nil {
+			return fmt.Errorf("unable to find the imports for scaffolded file '%s': %w", file, err)
+		}
+		if src, err = addImports(src, specs); err != nil {
+			return fmt.Errorf("unable to add imports to file '%s': %w", file, err)
+		}
+		src = append(append(src, '\n'), buf.Bytes()...)
+		res, err := format.Source(src)
+		if err != nil {
+			return fmt.Errorf("unable to format scaffolded file '%s': %w", file, err)
+		}
+		if err = ioutil.WriteFile(file, res, os.FileMode(0666)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stubImports returns the import specs that the file additionally needs for
+// the package qualifiers in the stubs.
+// They are taken from the other files of the package or the standard
+// library.
+func stubImports(src, stubs []byte, pkgImports map[string]string, dir string) ([]string, error) {
+	fset := token.NewFileSet()
+	astf, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, imp := range astf.Imports {
+		known[importName(imp)] = true
+	}
+	stubf, err := parser.ParseFile(fset, "", append([]byte("package stubs\n"), stubs...), 0)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]string, 0, 4)
+	ast.Inspect(stubf, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || known[x.Name] {
+			return true
+		}
+		known[x.Name] = true
+		spec := pkgImports[x.Name]
+		if spec == "" {
+			if p, err := build.Import(x.Name, dir, build.FindOnly); err == nil && p.Goroot {
+				spec = strconv.Quote(p.ImportPath)
+			}
+		}
+		if spec == "" {
+			log.Printf("WARNING: Unable to find the import of package '%s'.", x.Name)
+			return true
+		}
+		specs = append(specs, spec)
+		return true
+	})
+	return specs, nil
+}
+
+// addImports adds the import specs to the first import declaration of the
+// source or to a new one.
+func addImports(src []byte, specs []string) ([]byte, error) {
+	if len(specs) == 0 {
+		return src, nil
+	}
+	fset := token.NewFileSet()
+	astf, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(astf.Pos())
+	lines := "\t" + strings.Join(specs, "\n\t") + "\n"
+	insert := func(start, end int, text string) []byte {
+		return append(src[:start:start], append([]byte(text), src[end:]...)...)
+	}
+	for _, idecl := range astf.Decls {
+		decl, ok := idecl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		if decl.Lparen.IsValid() {
+			end := tf.Offset(decl.Rparen)
+			return insert(end, end, lines), nil
+		}
+		start, end := tf.Offset(decl.Pos()), tf.Offset(decl.End())
+		spec := string(src[tf.Offset(decl.Specs[0].Pos()):end])
+		return insert(start, end, "import (\n\t"+spec+"\n"+lines+")"), nil
+	}
+	end := tf.Offset(astf.Name.End())
+	return insert(end, end, "\n\nimport (\n"+lines+")"), nil
+}
+
+// addDeclaredNames adds the names of all top level declarations (including
+// methods) to the names and all functions to funcs.
+func addDeclaredNames(astf *ast.File, names map[string]bool, funcs map[string]*ast.FuncDecl) {
+	for _, idecl := range astf.Decls {
+		switch decl := idecl.(type) {
+		case *ast.FuncDecl:
+			names[decl.Name.Name] = true
+			if decl.Recv == nil {
+				funcs[decl.Name.Name] = decl
+			}
+		case *ast.GenDecl:
+			for _, s := range decl.Specs {
+				switch spec := s.(type) {
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						names[n.Name] = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// missingComponents returns all local components of the flow that don't
+// exist yet. The flow itself is included if it has no Go function.
+// The names of the returned components are added to names.
+func missingComponents(f *sourcePart, g *flowGraph, names map[string]bool) []*scaffoldComp {
+	comps := make([]*scaffoldComp, 0, len(g.nodes))
+	if !componentExists(f.name, names) && !strings.HasSuffix(f.goFile, ".go") {
+		c := &scaffoldComp{name: f.name, flow: f.name}
+		for _, e := range g.edges {
+			if e.from.kind == flowNodeInPort {
+				c.ins = addScaffoldPort(c.ins, e.from.name, "in", e.types)
+			}
+			if e.to.kind == flowNodeOutPort {
+				c.outs = addScaffoldPort(c.outs, e.to.name, "out", e.types)
+			}
+		}
+		comps = append(comps, c)
+		names[c.name] = true
+	}
+	for _, n := range g.nodes {
+		name := n.comp.LocalType
+		if n.kind != flowNodeComponent || n.comp.Package != "" || name == "" ||
+			n.comp.ListType != nil || n.comp.MapKeyType != nil || componentExists(name, names) {
+			continue
+		}
+		c := &scaffoldComp{name: name, flow: f.name, plugins: n.plugins}
+		for _, e := range g.edges {
+			if e.to == n {
+				c.ins = addScaffoldPort(c.ins, e.toPort, "in", e.types)
+			}
+			if e.from == n {
+				c.outs = addScaffoldPort(c.outs, e.fromPort, "out", e.types)
+			}
+		}
+		comps = append(comps, c)
+		names[name] = true
+	}
+	return comps
+}
+
+// componentExists tells if the component or one of its input port
+// functions has been declared.
+func componentExists(name string, names map[string]bool) bool {
+	if names[name] {
+		return true
+	}
+	for n := range names {
+		if strings.HasPrefix(n, name+"Port") {
+			return true
+		}
+	}
+	return false
+}
+func addScaffoldPort(ports []scaffoldPort, name, defaultName string, types []data.Type) []scaffoldPort {
+	if name == "" {
+		name = defaultName
+	}
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i] // array ports share a function
+	}
+	for i, p := range ports {
+		if p.name == name {
+			if len(p.types) == 0 {
+				ports[i].types = types
+			}
+			return ports
+		}
+	}
+	return append(ports, scaffoldPort{name: name, types: types})
+}
+
+// writeStubs writes the Go functions for all input ports of the component
+// and returns their names.
+func writeStubs(buf *bytes.Buffer, c *scaffoldComp, funcs map[string]*ast.FuncDecl, fset *token.FileSet,
+) []string {
+	ins := c.ins
+	if len(ins) == 0 {
+		ins = []scaffoldPort{{name: "in"}}
+	}
+	results := stubResults(c.outs)
+	fnames := make([]string, 0, len(ins))
+	for _, in := range ins {
+		fname, doc := c.name, "a component"
+		if c.name == c.flow {
+			doc = "the implementation"
+		}
+		if len(ins) > 1 || in.name != "in" {
+			fname += "Port" + upperFirst(in.name)
+			doc = "the input port '" + in.name + "'"
+			if c.name != c.flow {
+				doc += " of the component " + c.name
+			}
+		}
+		fnames = append(fnames, fname)
+		params := make([]string, 0, len(in.types)+len(c.plugins))
+		used := make(map[string]bool)
+		for _, t := range firstAlternative(in.types) {
+			params = append(params, uniqueName(paramName(t), used)+" "+dslTypeToString(t))
+		}
+		for _, p := range c.plugins {
+			params = append(params, pluginParam(p, funcs, fset))
+		}
+		buf.WriteString(fmt.Sprintf("\n// %s is %s of the flow %s.\n", fname, doc, c.flow))
+		buf.WriteString(fmt.Sprintf("func %s(%s)%s {\n\tpanic(%q)\n}\n",
+			fname, strings.Join(params, ", "), results, "TODO: implement "+fname))
+	}
+	return fnames
+}
+
+// stubResults returns the results of a function with the given output
+// ports according to the rules in RULES.md.
+func stubResults(outs []scaffoldPort) string {
+	hasErr := false
+	ports := make([]scaffoldPort, 0, len(outs))
+	for _, out := range outs {
+		if out.name == "err" || out.name == "error" {
+			hasErr = true
+		} else {
+			ports = append(ports, out)
+		}
+	}
+	results := make([]string, 0, len(ports)+1)
+	if len(ports) == 1 && ports[0].name == "out" {
+		for _, t := range firstAlternative(ports[0].types) {
+			results = append(results, dslTypeToString(t))
+		}
+		if hasErr {
+			results = append(results, "error")
+		}
+	} else {
+		for _, p := range ports {
+			results = append(results, "port"+upperFirst(p.name)+" "+portType(p.types))
+		}
+		if hasErr {
+			results = append(results, "err error")
+		}
+	}
+	switch {
+	case len(results) == 0:
+		return ""
+	case len(results) == 1 && !strings.Contains(results[0], " "):
+		return " " + results[0]
+	}
+	return " (" + strings.Join(results, ", ") + ")"
+}
+
+// portType returns the type of a named result: multiple data types are
+// wrapped in a struct.
+func portType(types []data.Type) string {
+	types = firstAlternative(types)
+	switch len(types) {
+	case 0:
+		return "interface{}"
+	case 1:
+		return dslTypeToString(types[0])
+	}
+	used := make(map[string]bool)
+	fields := make([]string, len(types))
+	for i, t := range types {
+		fields[i] = uniqueName(paramName(t), used) + " " + dslTypeToString(t)
+	}
+	return "*struct{ " + strings.Join(fields, "; ") + " }"
+}
+
+// pluginParam returns the parameter for a plugin with the signature of the
+// plugin's component if it is known.
+func pluginParam(p data.Plugin, funcs map[string]*ast.FuncDecl, fset *token.FileSet) string {
+	name := p.Name
+	if name == "" && len(p.Types) > 0 {
+		name = p.Types[0].LocalType
+	}
+	typ := "interface{}"
+	if len(p.Types) == 1 && p.Types[0].Package == "" {
+		if decl := funcs[p.Types[0].LocalType]; decl != nil {
+			b := &bytes.Buffer{}
+			if err := printer.Fprint(b, fset, decl.Type); err == nil {
+				typ = b.String()
+			}
+		}
+	}
+	return "plugin" + upperFirst(name) + " " + typ
+}
+
+// firstAlternative returns the data types up to the first separator.
+func firstAlternative(types []data.Type) []data.Type {
+	for i, t := range types {
+		if t.Separator() {
+			return types[:i]
+		}
+	}
+	return types
+}
+
+// paramName returns the lower case first letter of the type's name.
+func paramName(t data.Type) string {
+	for t.ListType != nil || t.MapValueType != nil {
+		if t.ListType != nil {
+			t = *t.ListType
+		} else {
+			t = *t.MapValueType
+		}
+	}
+	for _, r := range t.LocalType {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToLower(r))
+		}
+	}
+	return "v"
+}
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
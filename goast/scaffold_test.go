@@ -0,0 +1,165 @@
+package goast_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestScaffoldDirs(t *testing.T) {
+	specs := []struct {
+		name          string
+		givenFiles    map[string]string
+		expectedFiles map[string]string
+	}{
+		{
+			name: "comment-flow",
+			givenFiles: map[string]string{
+				"x.go": `package x
+
+// Bla handles orders.
+//
+// flow:
+//
+//	in (Order)-> [check] -> [enrich[getCustomer]] -> out
+//	[check] err-> error
+//	[check] fraud(Order, string)-> [report] -> fraud
+//	[check] bad-> x[report]
+func Bla(o Order) (Order, error) { return o, nil }
+
+func enrich(o Order) Order { return o }
+
+func getCustomer(id string) string { return id }
+`,
+			},
+			expectedFiles: map[string]string{
+				"x.go": `package x
+
+// Bla handles orders.
+//
+// flow:
+//
+//	in (Order)-> [check] -> [enrich[getCustomer]] -> out
+//	[check] err-> error
+//	[check] fraud(Order, string)-> [report] -> fraud
+//	[check] bad-> x[report]
+func Bla(o Order) (Order, error) { return o, nil }
+
+func enrich(o Order) Order { return o }
+
+func getCustomer(id string) string { return id }
+
+// check is a component of the flow Bla.
+func check(o Order) (portOut Order, portFraud *struct {
+	o Order
+	s string
+}, portBad interface{}, err error) {
+	panic("TODO: implement check")
+}
+
+// reportPortIn is the input port 'in' of the component report of the flow Bla.
+func reportPortIn(o Order, s string) (Order, string) {
+	panic("TODO: implement reportPortIn")
+}
+
+// reportPortX is the input port 'x' of the component report of the flow Bla.
+func reportPortX() (Order, string) {
+	panic("TODO: implement reportPortX")
+}
+`,
+			},
+		}, {
+			name: "flow-file",
+			givenFiles: map[string]string{
+				"x.go": "package x\n\ntype Order int\n",
+				"design.flow": `flow: Design
+
+in (Order)-> [check] -> [store[load=getCustomer]] -> out
+[check] err-> err
+`,
+			},
+			expectedFiles: map[string]string{
+				"design.go": `package x
+
+// Design is the implementation of the flow Design.
+func Design(o Order) (Order, error) {
+	panic("TODO: implement Design")
+}
+
+// check is a component of the flow Design.
+func check(o Order) (Order, error) {
+	panic("TODO: implement check")
+}
+
+// store is a component of the flow Design.
+func store(o Order, pluginLoad interface{}) Order {
+	panic("TODO: implement store")
+}
+`,
+			},
+		}, {
+			name: "qualified-types",
+			givenFiles: map[string]string{
+				"x.go": "package x\n\nimport shop \"example.com/shop/order\"\n\nvar o shop.Order\n",
+				"y.go": `package x
+
+import "fmt"
+
+// Parse parses orders.
+//
+// flow:
+//
+//	in (io.Reader)-> [decode] (shop.Order)-> [fmt.Println] -> out
+func Parse(r io.Reader) { fmt.Println(r) }
+`,
+			},
+			expectedFiles: map[string]string{
+				"y.go": `package x
+
+import (
+	shop "example.com/shop/order"
+	"fmt"
+	"io"
+)
+
+// Parse parses orders.
+//
+// flow:
+//
+//	in (io.Reader)-> [decode] (shop.Order)-> [fmt.Println] -> out
+func Parse(r io.Reader) { fmt.Println(r) }
+
+// decode is a component of the flow Parse.
+func decode(r io.Reader) shop.Order {
+	panic("TODO: implement decode")
+}
+`,
+			},
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range spec.givenFiles {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0666)); err != nil {
+					t.Fatalf("Unable to write file: %v", err)
+				}
+			}
+			if err := goast.ScaffoldDirs([]string{dir}, false); err != nil {
+				t.Fatalf("Unable to scaffold directory: %v", err)
+			}
+			for name, expected := range spec.expectedFiles {
+				actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("Unable to read file: %v", err)
+				}
+				if string(actual) != expected {
+					t.Errorf("Expected file %s:\n%s\nbut got:\n%s", name, expected, actual)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serveVersionPath = "/_go2md/version"
+	serveIndexStart  = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flow Documentation</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+.error { color: #900; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Flow Documentation</h1>
+`
+	serveNav = `<nav><a href="/">Index</a> | %s</nav>
+`
+	serveReload = `<script>
+(function() {
+	var version = "%s";
+	setInterval(function() {
+		fetch("` + serveVersionPath + `").then(function(resp) {
+			return resp.text();
+		}).then(function(v) {
+			if (v !== version) {
+				location.reload();
+			}
+		}).catch(function() {});
+	}, 1000);
+})();
+</script>
+`
+)
+
+var serveFlowRegex = regexp.MustCompile(`<h2 id="(flow-[^"]*)">Flow: <a [^>]*>([^<]*)</a></h2>`)
+
+// server serves the documentation that is kept in memory.
+type server struct {
+	mutex    sync.Mutex
+	packDict *packageDict
+	cwd      string
+	version  int
+	err      error // error of the last run
+}
+
+// Serve serves the documentation of the directories via HTTP at the given
+// address until stop is closed.
+// The documentation is generated in memory (nothing is written into the
+// source tree) and regenerated whenever a Go file changes.
+// Pages in the browser are reloaded automatically after each change.
+func Serve(addr string, dirs []string, packDict *packageDict, interval time.Duration, stop <-chan struct{}) error {
+	if _, ok := packDict.render.(htmlRenderer); !ok {
+		return fmt.Errorf("serving requires the %q format", FormatHTML)
+	}
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return fmt.Errorf("unable to get working directory: %w", err)
+	}
+	packDict.mem = newMemFiles()
+	s := &server{packDict: packDict, cwd: cwd}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen at '%s': %w", addr, err)
+	}
+	srv := &http.Server{Handler: s}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("ERROR: Unable to serve documentation: %v", err)
+		}
+	}()
+	fmt.Printf("Serving documentation at: http://%s/\n", ln.Addr())
+
+	watchDirs(dirs, packDict, interval, stop, s.runDone)
+	return srv.Close()
+}
+
+// runDone is called after each run of the documentation generation.
+func (s *server) runDone(err error) {
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.version++
+	s.err = err
+}
+func (s *server) state() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return strconv.Itoa(s.version), s.err
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	version, runErr := s.state()
+	switch urlPath {
+	case serveVersionPath:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(version))
+		return
+	case "/":
+		s.serveIndex(w, version, runErr)
+		return
+	}
+
+	name := filepath.Join(s.cwd, filepath.FromSlash(urlPath))
+	content, ok := s.packDict.mem.get(name)
+	if !ok && (strings.HasSuffix(name, ".go") || strings.HasSuffix(name, flowFileExt)) { // links to the source code
+		var err error
+		if content, err = ioutil.ReadFile(name); err == nil {
+			ok = true
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ext := filepath.Ext(name)
+	if ext == ".html" {
+		content = s.decoratePage(content, urlPath, version, runErr)
+	}
+	ctype := mime.TypeByExtension(ext)
+	if ctype == "" || ext == ".go" || ext == flowFileExt || ext == ".md" {
+		ctype = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(content)
+}
+
+// serveIndex lists all documents with their flows grouped by directory.
+func (s *server) serveIndex(w http.ResponseWriter, version string, runErr error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(serveIndexStart)
+	writeServeError(buf, runErr)
+	lastDir := ""
+	for _, name := range s.packDict.mem.names() {
+		rel, err := filepath.Rel(s.cwd, name)
+		if err != nil || filepath.Ext(name) == ".svg" {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if dir := path.Dir(rel); dir != lastDir {
+			if lastDir != "" {
+				buf.WriteString("</ul>\n")
+			}
+			buf.WriteString("<h2>" + html.EscapeString(dir) + "</h2>\n<ul>\n")
+			lastDir = dir
+		}
+		buf.WriteString(`<li><a href="/` + html.EscapeString(rel) + `">` +
+			html.EscapeString(path.Base(rel)) + "</a>")
+		content, _ := s.packDict.mem.get(name)
+		flows := serveFlowRegex.FindAllSubmatch(content, -1)
+		if len(flows) > 0 {
+			buf.WriteString("\n<ul>\n")
+			for _, m := range flows {
+				buf.WriteString(`<li><a href="/` + html.EscapeString(rel) + "#" + string(m[1]) + `">` +
+					string(m[2]) + "</a></li>\n")
+			}
+			buf.WriteString("</ul>\n")
+		}
+		buf.WriteString("</li>\n")
+	}
+	if lastDir != "" {
+		buf.WriteString("</ul>\n")
+	}
+	buf.WriteString(fmt.Sprintf(serveReload, version))
+	buf.WriteString(htmlEnd)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// decoratePage adds the navigation, the last error and the live reload
+// script to a generated page.
+func (s *server) decoratePage(content []byte, urlPath, version string, runErr error) []byte {
+	top := &bytes.Buffer{}
+	top.WriteString(fmt.Sprintf(serveNav, html.EscapeString(strings.TrimPrefix(urlPath, "/"))))
+	writeServeError(top, runErr)
+	content = bytes.Replace(content, []byte("<body>\n"), append([]byte("<body>\n"), top.Bytes()...), 1)
+	return bytes.Replace(content, []byte("</body>"),
+		[]byte(fmt.Sprintf(serveReload, version)+"</body>"), 1)
+}
+
+func writeServeError(buf *bytes.Buffer, err error) {
+	if err != nil {
+		buf.WriteString(`<p class="error">ERROR: ` + html.EscapeString(err.Error()) + "</p>\n")
+	}
+}
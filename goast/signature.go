@@ -0,0 +1,128 @@
+package goast
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+	"unicode"
+)
+
+// funcSig is the signature of a component function interpreted according to
+// the rules in RULES.md.
+type funcSig struct {
+	inPort  string
+	params  []string // input parameters without plugins
+	outs    []sigPort
+	plugins []string
+	summary string // first line of the doc comment
+}
+
+// sigPort is an output port with the types of its return values.
+type sigPort struct {
+	name  string
+	types []string
+}
+
+// newFuncSig interprets the declaration of a function as component.
+// Parameters of function type are plugins.
+func newFuncSig(decl *ast.FuncDecl) *funcSig {
+	sig := &funcSig{
+		inPort:  inPortName(decl.Name.Name),
+		summary: strings.TrimSpace(strings.SplitN(decl.Doc.Text(), "\n", 2)[0]),
+	}
+	for _, field := range decl.Type.Params.List {
+		typ := types.ExprString(field.Type)
+		names := fieldNames(field)
+		for _, name := range names {
+			param := strings.TrimSpace(name + " " + typ)
+			if _, ok := field.Type.(*ast.FuncType); ok {
+				sig.plugins = append(sig.plugins, param)
+			} else {
+				sig.params = append(sig.params, param)
+			}
+		}
+	}
+	if decl.Type.Results == nil {
+		return sig
+	}
+	last := len(decl.Type.Results.List) - 1
+	for i, field := range decl.Type.Results.List {
+		typ := types.ExprString(field.Type)
+		for _, name := range fieldNames(field) {
+			port := "out"
+			if strings.HasPrefix(name, "port") && len(name) > 4 {
+				port = strings.ToLower(name[4:5]) + name[5:]
+			} else if typ == "error" && i == last {
+				port = "err"
+			}
+			sig.outs = addSigPort(sig.outs, port, typ)
+		}
+	}
+	return sig
+}
+
+// inPortName returns the name of the input port of the function:
+// 'fooPortBar' and 'foo_Bar' are the port 'bar' of the component 'foo'.
+func inPortName(name string) string {
+	if i := strings.Index(name, "_"); i >= 0 && i+1 < len(name) {
+		return lowerFirst(name[i+1:])
+	}
+	if i := strings.Index(name, "Port"); i > 0 && i+4 < len(name) &&
+		unicode.IsUpper(rune(name[i+4])) {
+		return lowerFirst(name[i+4:])
+	}
+	return "in"
+}
+
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) == 0 {
+		return []string{""}
+	}
+	names := make([]string, len(field.Names))
+	for i, n := range field.Names {
+		names[i] = n.Name
+	}
+	return names
+}
+
+func addSigPort(ports []sigPort, name, typ string) []sigPort {
+	for i := range ports {
+		if ports[i].name == name {
+			ports[i].types = append(ports[i].types, typ)
+			return ports
+		}
+	}
+	return append(ports, sigPort{name: name, types: []string{typ}})
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// signatureLinks returns the links to components with a known signature.
+func signatureLinks(compLinks []link) []link {
+	links := make([]link, 0, len(compLinks))
+	for _, l := range compLinks {
+		if l.part != nil && l.part.sig != nil {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// input returns the input port with its parameters: 'in(i int)'.
+func (sig *funcSig) input() string {
+	return sig.inPort + "(" + strings.Join(sig.params, ", ") + ")"
+}
+
+// output returns all output ports with their types: 'out(int), err(error)'.
+func (sig *funcSig) output() []string {
+	outs := make([]string, len(sig.outs))
+	for i, p := range sig.outs {
+		outs[i] = p.name + "(" + strings.Join(p.types, ", ") + ")"
+	}
+	return outs
+}
@@ -0,0 +1,467 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// suggestNode is the source of data in a function: an outer input port
+// (comp is empty) or an output port of a component.
+type suggestNode struct {
+	comp  string
+	port  string
+	types []string
+}
+
+// suggestEdge is an arrow of the suggested flow.
+type suggestEdge struct {
+	from    suggestNode
+	to      string // component or outer output port
+	toOuter bool
+	written bool
+}
+
+// suggestion is a suggested flow for a function.
+type suggestion struct {
+	decl *ast.FuncDecl
+	dsl  []string
+}
+
+// SuggestDirs suggests flows for all functions without a flow in the given
+// directories.
+// The suggestion is derived from the calls of other functions of the
+// package: the results of a call that feed the next call become arrows,
+// error results become 'err' ports and results named 'portXxx' become
+// ports named 'xxx'.
+// The suggestions are written to w or inserted as doc comments if write
+// is true.
+func SuggestDirs(dirs []string, write bool, w io.Writer) error {
+	for _, dir := range dirs {
+		fset := token.NewFileSet()
+		pkgs, err := parser.ParseDir(fset, dir, excludeTests, parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("unable to parse the directory '%s': %w", dir, err)
+		}
+		for _, pkg := range sortedPackages(pkgs) {
+			if isTestPackage(pkg.Name) {
+				continue
+			}
+			if err = suggestPackage(pkg, dir, fset, write, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func suggestPackage(pkg *ast.Package, dir string, fset *token.FileSet, write bool, w io.Writer) error {
+	flowFiles, err := findFlowFiles(make(map[string]*sourcePart), nil, dir, "")
+	if err != nil {
+		return err
+	}
+	bound := make(map[string]bool, len(flowFiles)) // functions documented by flow files
+	for _, f := range flowFiles {
+		bound[f.name] = true
+	}
+	funcs := make(map[string]*ast.FuncDecl) // functions and methods that can be components
+	types := make(map[string]bool)
+	for _, astf := range pkg.Files {
+		for _, idecl := range astf.Decls {
+			switch decl := idecl.(type) {
+			case *ast.FuncDecl:
+				funcs[decl.Name.Name] = decl
+			case *ast.GenDecl:
+				for _, s := range decl.Specs {
+					if ts, ok := s.(*ast.TypeSpec); ok {
+						types[ts.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, name := range sortedFileNames(pkg.Files) {
+		suggestions := make([]suggestion, 0, 8)
+		for _, idecl := range pkg.Files[name].Decls {
+			decl, ok := idecl.(*ast.FuncDecl)
+			if !ok || decl.Body == nil {
+				continue
+			}
+			if blocks, _ := extractFlows(decl.Doc.Text()); len(blocks) > 0 ||
+				(decl.Recv == nil && bound[decl.Name.Name]) {
+				continue // already documented
+			}
+			dsl := suggestFlow(decl, funcs, types, importMap(pkg.Files[name].Imports))
+			if len(dsl) == 0 {
+				continue
+			}
+			if _, err := parseFlowDSL(strings.Join(dsl, "\n")+"\n", decl.Name.Name); err != nil {
+				log.Printf("WARNING: Unable to suggest a valid flow for %s: %v", decl.Name.Name, err)
+				continue
+			}
+			suggestions = append(suggestions, suggestion{decl: decl, dsl: dsl})
+		}
+		if len(suggestions) == 0 {
+			continue
+		}
+		if !write {
+			for _, s := range suggestions {
+				fmt.Fprintf(w, "%s: %s\n", fset.Position(s.decl.Pos()), s.decl.Name.Name)
+				for _, line := range s.dsl {
+					fmt.Fprintln(w, dslMarker+line)
+				}
+			}
+			continue
+		}
+		if err := insertSuggestions(name, suggestions, fset, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertSuggestions adds the suggested flows to the doc comments of their
+// functions.
+func insertSuggestions(file string, suggestions []suggestion, fset *token.FileSet, w io.Writer) error {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read Go file: %w", err)
+	}
+	tf := fset.File(suggestions[0].decl.Pos())
+	for i := len(suggestions) - 1; i >= 0; i-- { // from the end so offsets stay valid
+		s := suggestions[i]
+		buf := &bytes.Buffer{}
+		if s.decl.Doc != nil {
+			buf.WriteString("//\n")
+		}
+		buf.WriteString("// flow:\n//\n")
+		for _, line := range s.dsl {
+			buf.WriteString("//\t" + line + "\n")
+		}
+		off := tf.Offset(s.decl.Pos())
+		src = append(src[:off:off], append(buf.Bytes(), src[off:]...)...)
+	}
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "Adding flow to %s: %s\n", file, s.decl.Name.Name)
+	}
+	return ioutil.WriteFile(file, src, os.FileMode(0666))
+}
+
+// suggestFlow returns the lines of the suggested flow for the function or
+// nil if the function doesn't call at least two components.
+func suggestFlow(
+	decl *ast.FuncDecl, funcs map[string]*ast.FuncDecl, types map[string]bool, imps map[string]string,
+) []string {
+	vars := make(map[string]suggestNode) // sources of all variables
+	for _, field := range decl.Type.Params.List {
+		for _, n := range field.Names {
+			vars[n.Name] = suggestNode{port: "in", types: dslTypesOf(field.Type, 1)}
+		}
+	}
+	calls := make(map[*ast.CallExpr]suggestNode) // results of the component calls
+	comps := make([]string, 0, 8)
+	edges := make([]*suggestEdge, 0, 16)
+	addEdge := func(from suggestNode, to string, toOuter bool) {
+		for _, e := range edges {
+			if e.from.comp == from.comp && e.from.port == from.port && e.to == to && e.toOuter == toOuter {
+				return
+			}
+		}
+		edges = append(edges, &suggestEdge{from: from, to: to, toOuter: toOuter})
+	}
+
+	assigns := make(map[*ast.CallExpr][]ast.Expr) // left hand sides of the calls
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStmt); ok && len(as.Rhs) == 1 {
+			if call, ok := as.Rhs[0].(*ast.CallExpr); ok {
+				assigns[call] = as.Lhs
+			}
+		}
+		if vs, ok := n.(*ast.ValueSpec); ok && len(vs.Values) == 1 {
+			if call, ok := vs.Values[0].(*ast.CallExpr); ok {
+				lhs := make([]ast.Expr, len(vs.Names))
+				for i, name := range vs.Names {
+					lhs[i] = name
+				}
+				assigns[call] = lhs
+			}
+		}
+		return true
+	})
+
+	var prev string
+	for _, call := range bodyCalls(decl.Body) {
+		name, callee := componentCall(call, funcs, types, imps)
+		if callee == nil {
+			continue
+		}
+		comps = appendUnique(comps, name)
+		sources := make([]suggestNode, 0, 2)
+		for _, arg := range call.Args {
+			if src, ok := sourceOf(arg, vars, calls, types); ok {
+				sources = append(sources, src)
+			}
+		}
+		if len(call.Args) == 0 && prev != "" { // sequential calls
+			sources = append(sources, suggestNode{comp: prev, port: "out"})
+		}
+		for _, src := range mergeInPorts(sources) {
+			addEdge(src, name, false)
+		}
+		prev = name
+
+		results := resultPorts(callee.Type.Results)
+		if len(results) > 0 {
+			calls[call] = suggestNode{comp: name, port: results[0].port, types: results[0].types}
+		}
+		for i, lhs := range assigns[call] {
+			if id, ok := lhs.(*ast.Ident); ok && id.Name != "_" && i < len(results) {
+				vars[id.Name] = suggestNode{comp: name, port: results[i].port, types: results[i].types}
+			}
+		}
+	}
+	if len(comps) < 2 {
+		return nil
+	}
+
+	outs := resultPorts(decl.Type.Results)
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false // returns of other functions
+		}
+		ret, ok := n.(*ast.ReturnStmt)
+		if !ok {
+			return true
+		}
+		for i, res := range ret.Results {
+			if src, ok := sourceOf(res, vars, calls, types); ok && src.comp != "" && i < len(outs) {
+				if len(ret.Results) == 1 && len(outs) > 1 { // returning all results of a call
+					for _, out := range outs {
+						addEdge(suggestNode{comp: src.comp, port: out.port}, out.port, true)
+					}
+					continue
+				}
+				addEdge(src, outs[i].port, true)
+			}
+		}
+		return true
+	})
+	return writeSuggestedFlow(edges)
+}
+
+// componentCall returns the name and declaration of the called component
+// or nil if the call isn't a call of a component of the package.
+func componentCall(
+	call *ast.CallExpr, funcs map[string]*ast.FuncDecl, types map[string]bool, imps map[string]string,
+) (string, *ast.FuncDecl) {
+	var name string
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		name = fun.Name
+		if decl := funcs[name]; decl != nil && decl.Recv != nil {
+			return "", nil // a method needs a receiver
+		}
+	case *ast.SelectorExpr:
+		name = fun.Sel.Name
+		if decl := funcs[name]; decl != nil && decl.Recv == nil {
+			return "", nil // a function of another package
+		}
+		if id, ok := fun.X.(*ast.Ident); ok && id.Obj == nil && imps[id.Name] != "" {
+			return "", nil // a function of an imported package
+		}
+	}
+	if types[name] || isConstructor(name, types) {
+		return "", nil // type conversion or constructor
+	}
+	return name, funcs[name]
+}
+
+// isConstructor tells if the name is 'New' followed by an exported type of
+// the package.
+func isConstructor(name string, types map[string]bool) bool {
+	typ := strings.TrimPrefix(name, "New")
+	return typ != name && ast.IsExported(typ) && types[typ]
+}
+
+// sourceOf returns the source of the data of the expression.
+func sourceOf(
+	expr ast.Expr, vars map[string]suggestNode, calls map[*ast.CallExpr]suggestNode, types map[string]bool,
+) (suggestNode, bool) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		src, ok := vars[x.Name]
+		return src, ok
+	case *ast.CallExpr:
+		if src, ok := calls[x]; ok {
+			return src, true
+		}
+		if len(x.Args) != 1 {
+			return suggestNode{}, false
+		}
+		src, ok := sourceOf(x.Args[0], vars, calls, types)
+		if id, isIdent := x.Fun.(*ast.Ident); ok && isIdent && types[id.Name] { // type conversion
+			src.types = []string{id.Name}
+		}
+		return src, ok
+	case *ast.ParenExpr:
+		return sourceOf(x.X, vars, calls, types)
+	case *ast.StarExpr:
+		return sourceOf(x.X, vars, calls, types)
+	case *ast.UnaryExpr:
+		return sourceOf(x.X, vars, calls, types)
+	}
+	return suggestNode{}, false
+}
+
+// mergeInPorts merges all parameters from the outer input port into one
+// arrow.
+func mergeInPorts(sources []suggestNode) []suggestNode {
+	result := make([]suggestNode, 0, len(sources))
+	in := -1
+	for _, src := range sources {
+		if src.comp == "" {
+			if in >= 0 {
+				result[in].types = append(append([]string{}, result[in].types...), src.types...)
+				continue
+			}
+			in = len(result)
+		}
+		result = append(result, src)
+	}
+	return result
+}
+
+// resultPorts returns the ports of the results according to the rules in
+// RULES.md.
+func resultPorts(results *ast.FieldList) []suggestNode {
+	if results == nil {
+		return nil
+	}
+	ports := make([]suggestNode, 0, len(results.List))
+	for i, field := range results.List {
+		names := make([]string, 0, len(field.Names))
+		for _, n := range field.Names {
+			names = append(names, n.Name)
+		}
+		if len(names) == 0 {
+			names = append(names, "")
+		}
+		for _, name := range names {
+			port := "out"
+			if strings.HasPrefix(name, "port") && len(name) > 4 {
+				port = strings.ToLower(name[4:5]) + name[5:]
+			} else if id, ok := field.Type.(*ast.Ident); ok && id.Name == "error" && i == len(results.List)-1 {
+				port = "err"
+			}
+			ports = append(ports, suggestNode{port: port, types: dslTypesOf(field.Type, 1)})
+		}
+	}
+	return ports
+}
+
+// dslTypesOf returns the type as it can be used in the flow DSL or nil.
+func dslTypesOf(expr ast.Expr, n int) []string {
+	typ := dslTypeOf(expr)
+	if typ == "" {
+		return nil
+	}
+	types := make([]string, n)
+	for i := range types {
+		types[i] = typ
+	}
+	return types
+}
+func dslTypeOf(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if x.Name == "error" {
+			return ""
+		}
+		return x.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok {
+			return pkg.Name + "." + x.Sel.Name
+		}
+	case *ast.StarExpr:
+		return dslTypeOf(x.X)
+	case *ast.ArrayType:
+		if elem := dslTypeOf(x.Elt); elem != "" && x.Len == nil {
+			return "[]" + elem
+		}
+	case *ast.MapType:
+		if key, val := dslTypeOf(x.Key), dslTypeOf(x.Value); key != "" && val != "" {
+			return "map[" + key + "]" + val
+		}
+	}
+	return ""
+}
+
+// writeSuggestedFlow writes the edges as lines of the flow DSL.
+// Every line follows the data as far as possible.
+func writeSuggestedFlow(edges []*suggestEdge) []string {
+	lines := make([]string, 0, 8)
+	for _, start := range edges {
+		if start.written {
+			continue
+		}
+		b := &strings.Builder{}
+		if start.from.comp == "" {
+			b.WriteString(start.from.port + " ")
+		} else {
+			b.WriteString("[" + start.from.comp + "] ")
+			if start.from.port != "out" {
+				b.WriteString(start.from.port)
+			}
+		}
+		lastTypes := []string(nil)
+		for e := start; e != nil; e = nextEdge(edges, e) {
+			if e != start && e.from.port != "out" {
+				b.WriteString(" " + e.from.port)
+			}
+			if len(e.from.types) > 0 && strings.Join(e.from.types, ",") != strings.Join(lastTypes, ",") {
+				b.WriteString(" (" + strings.Join(e.from.types, ", ") + ")")
+				lastTypes = e.from.types
+			}
+			b.WriteString("-> ")
+			if e.toOuter {
+				b.WriteString(e.to)
+			} else {
+				b.WriteString("[" + e.to + "]")
+			}
+			e.written = true
+		}
+		lines = append(lines, formatDSLLine(b.String()))
+	}
+	return lines
+}
+
+// nextEdge returns the first unwritten edge starting at the target of the
+// edge.
+func nextEdge(edges []*suggestEdge, e *suggestEdge) *suggestEdge {
+	if e.toOuter {
+		return nil
+	}
+	for _, next := range edges {
+		if !next.written && next.from.comp == e.to {
+			return next
+		}
+	}
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, l := range list {
+		if l == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,139 @@
+package goast_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestSuggestDirs(t *testing.T) {
+	const src = `package x
+
+type Order int
+
+// Check checks the order.
+func Check(o Order, s string) (portOut Order, portBad *Order, err error) {
+	x, err := load(o, s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if x > 3 {
+		return 0, bad(x), nil
+	}
+	return store(x), nil, nil
+}
+
+func Simple(i int) int {
+	return double(inc(i))
+}
+
+func load(o Order, s string) (Order, error) { return o, nil }
+func bad(o Order) *Order                    { return &o }
+func store(o Order) Order                   { return o }
+func inc(i int) int                         { return i + 1 }
+func double(i int) int                      { return i * 2 }
+`
+	specs := []struct {
+		name           string
+		givenSource    string
+		givenFlowFile  string
+		givenWrite     bool
+		expectedOutput string
+		expectedSource string
+	}{
+		{
+			name: "print",
+			expectedOutput: `x.go:6:1: Check
+    in (Order, string)-> [load] (Order)-> [bad] -> bad
+    [load] (Order)-> [store] -> out
+    [load] err -> err
+x.go:17:1: Simple
+    in (int)-> [inc] -> [double] -> out
+`,
+			expectedSource: src,
+		}, {
+			name:       "write",
+			givenWrite: true,
+			expectedOutput: `Adding flow to x.go: Check
+Adding flow to x.go: Simple
+`,
+			expectedSource: strings.Replace(strings.Replace(src,
+				"// Check checks the order.\n",
+				"// Check checks the order.\n//\n// flow:\n//\n"+
+					"//\tin (Order, string)-> [load] (Order)-> [bad] -> bad\n"+
+					"//\t[load] (Order)-> [store] -> out\n//\t[load] err -> err\n", 1),
+				"func Simple", "// flow:\n//\n//\tin (int)-> [inc] -> [double] -> out\nfunc Simple", 1),
+		}, {
+			name:          "flow-file",
+			givenFlowFile: "flow: Simple\n\nin (int)-> [inc] -> [double] -> out\n",
+			expectedOutput: `x.go:6:1: Check
+    in (Order, string)-> [load] (Order)-> [bad] -> bad
+    [load] (Order)-> [store] -> out
+    [load] err -> err
+`,
+			expectedSource: src,
+		}, {
+			name: "no-components",
+			givenSource: `package x
+
+import "strings"
+
+type Reader int
+
+func Mail(s string) string {
+	r := NewReader()
+	return Newsletter(clean(strings.TrimSpace(s), r))
+}
+
+func (r Reader) TrimSpace(s string) string { return s }
+func NewReader() Reader                    { return 0 }
+func Newsletter(s string) string           { return s }
+func clean(s string, r Reader) string      { return s }
+`,
+			expectedOutput: `x.go:7:1: Mail
+    in (string)-> [clean] -> [Newsletter] -> out
+`,
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			if spec.givenSource == "" {
+				spec.givenSource = src
+			}
+			if spec.expectedSource == "" {
+				spec.expectedSource = spec.givenSource
+			}
+			dir := t.TempDir()
+			file := filepath.Join(dir, "x.go")
+			if err := ioutil.WriteFile(file, []byte(spec.givenSource), os.FileMode(0666)); err != nil {
+				t.Fatalf("Unable to write file: %v", err)
+			}
+			if spec.givenFlowFile != "" {
+				flowFile := filepath.Join(dir, "simple.flow")
+				if err := ioutil.WriteFile(flowFile, []byte(spec.givenFlowFile), os.FileMode(0666)); err != nil {
+					t.Fatalf("Unable to write file: %v", err)
+				}
+			}
+			out := &bytes.Buffer{}
+			if err := goast.SuggestDirs([]string{dir}, spec.givenWrite, out); err != nil {
+				t.Fatalf("Unable to suggest flows: %v", err)
+			}
+			actualOutput := strings.ReplaceAll(out.String(), dir+string(filepath.Separator), "")
+			if actualOutput != spec.expectedOutput {
+				t.Errorf("Expected output:\n%s\nbut got:\n%s", spec.expectedOutput, actualOutput)
+			}
+			actualSource, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Unable to read file: %v", err)
+			}
+			if string(actualSource) != spec.expectedSource {
+				t.Errorf("Expected source:\n%s\nbut got:\n%s", spec.expectedSource, actualSource)
+			}
+		})
+	}
+}
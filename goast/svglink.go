@@ -0,0 +1,178 @@
+package goast
+
+import (
+	"bytes"
+	"html"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+const (
+	svgStart  = "<svg "
+	xlinkAttr = `xmlns:xlink="http://www.w3.org/1999/xlink" `
+)
+
+var (
+	svgRectRegex = regexp.MustCompile(
+		`^\s*<rect .*width="(\d+)" height="(\d+)" x="(\d+)" y="(\d+)"`)
+	svgTextRegex = regexp.MustCompile(
+		`^\s*<text .* x="(\d+)" y="(\d+)" .*>(.*)</text>\s*$`)
+	svgWordRegex = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_.]*`)
+)
+
+type svgBox struct {
+	line       int
+	x, y, w, h int
+}
+
+// linkSVG makes the texts of a SVG diagram clickable.
+// The given box links map whole texts to their URLs.
+// The smallest box around such a text is made clickable, too.
+// The word links map single words (e.g. data types) inside of data texts to
+// their URLs.
+func linkSVG(svg []byte, boxLinks, wordLinks map[string]string) []byte {
+	if len(boxLinks) == 0 && len(wordLinks) == 0 {
+		return svg
+	}
+	lines := bytes.Split(svg, []byte("\n"))
+	boxes := make([]svgBox, 0, 32)
+	for i, line := range lines {
+		if m := svgRectRegex.FindSubmatch(line); m != nil {
+			boxes = append(boxes, svgBox{
+				line: i,
+				w:    atoi(m[1]), h: atoi(m[2]),
+				x: atoi(m[3]), y: atoi(m[4]),
+			})
+		}
+	}
+
+	linked := false
+	linkedBoxes := make(map[int]string)
+	for i, line := range lines {
+		m := svgTextRegex.FindSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		text := line[m[6]:m[7]]
+		if url := boxLinks[html.UnescapeString(string(text))]; url != "" {
+			lines[i] = wrapSVGLink(line, url)
+			linked = true
+			if b := smallestBoxAround(boxes, atoi(line[m[2]:m[3]]), atoi(line[m[4]:m[5]])); b != nil {
+				if _, ok := linkedBoxes[b.line]; !ok {
+					linkedBoxes[b.line] = url
+				}
+			}
+		} else if isDataText(text) {
+			if newText, ok := linkWords(text, wordLinks); ok {
+				lines[i] = append(append(append([]byte{}, line[:m[6]]...), newText...), line[m[7]:]...)
+				linked = true
+			}
+		}
+	}
+	for i, url := range linkedBoxes {
+		lines[i] = wrapSVGLink(lines[i], url)
+	}
+	result := bytes.Join(lines, []byte("\n"))
+	if linked {
+		result = bytes.Replace(result, []byte(svgStart), []byte(svgStart+xlinkAttr), 1)
+	}
+	return result
+}
+
+// isDataText tells if the text of a SVG diagram belongs to the data of an
+// arrow.
+func isDataText(text []byte) bool {
+	return bytes.HasPrefix(text, []byte("(")) || bytes.HasPrefix(text, []byte(" ")) ||
+		bytes.HasSuffix(text, []byte(")")) || bytes.HasSuffix(text, []byte(","))
+}
+
+// linkWords links all words of the text that have got a link.
+func linkWords(text []byte, wordLinks map[string]string) ([]byte, bool) {
+	linked := false
+	result := svgWordRegex.ReplaceAllFunc(text, func(word []byte) []byte {
+		url := wordLinks[string(word)]
+		if url == "" {
+			return word
+		}
+		linked = true
+		return []byte(`<a xlink:href="` + html.EscapeString(url) + `">` + string(word) + "</a>")
+	})
+	return result, linked
+}
+func wrapSVGLink(line []byte, url string) []byte {
+	i := len(line) - len(bytes.TrimLeft(line, " \t"))
+	b := make([]byte, 0, len(line)+len(url)+32)
+	b = append(b, line[:i]...)
+	b = append(b, `<a xlink:href="`+html.EscapeString(url)+`">`...)
+	b = append(b, line[i:]...)
+	return append(b, "</a>"...)
+}
+func smallestBoxAround(boxes []svgBox, x, y int) *svgBox {
+	var found *svgBox
+	for i := range boxes {
+		b := &boxes[i]
+		if x < b.x || x > b.x+b.w || y < b.y || y > b.y+b.h {
+			continue
+		}
+		if found == nil || b.w*b.h < found.w*found.h {
+			found = b
+		}
+	}
+	return found
+}
+func atoi(b []byte) int {
+	i, _ := strconv.Atoi(string(b))
+	return i
+}
+
+// componentLinks finds the links of the components of a flow graph.
+// If onlyFlows is true, only components that are flows themselves are
+// linked.
+// The keys of the result are the texts of the components in the diagram.
+func componentLinks(
+	f *sourcePart, g *flowGraph, partMap map[string]*sourcePart, onlyFlows bool,
+) map[string]string {
+	links := make(map[string]string)
+	for _, n := range g.nodes {
+		if n.kind != flowNodeComponent {
+			continue
+		}
+		l := getLinkForComponent(n.comp, partMap, f.mdFile)
+		if l.part == nil || (onlyFlows && l.part.kind != sourcePartFlow) {
+			continue
+		}
+		addNodeLinks(links, n, absoluteDocURL(l.url, f.mdFile))
+	}
+	return links
+}
+
+// typeLinks maps the names of the linked data types to their URLs.
+func typeLinks(dataLinks []link) map[string]string {
+	links := make(map[string]string, len(dataLinks))
+	for _, l := range dataLinks {
+		links[l.name] = l.url
+	}
+	return links
+}
+func addNodeLinks(links map[string]string, n *flowNode, url string) {
+	for _, text := range n.label[:min(2, len(n.label))] {
+		links[text] = url
+	}
+}
+
+// absoluteDocURL adds the name of the documentation file to URLs that
+// only contain an anchor, so they work in separate SVG files, too.
+func absoluteDocURL(url string, mdFile *mdFile) string {
+	if url != "" && url[0] == '#' {
+		return filepath.Base(mdFile.name) + mdFile.fImps.packDict.render.fileExt() + url
+	}
+	return url
+}
+
+func min(a, b int) int {
+	if a <= b {
+		return a
+	}
+	return b
+}
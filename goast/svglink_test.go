@@ -0,0 +1,61 @@
+package goast
+
+import (
+	"testing"
+)
+
+func TestLinkSVG(t *testing.T) {
+	givenSVG := `<svg version="1.1" width="100px" height="60px">
+	<rect fill="white" width="100" height="60" x="0" y="0"/>
+	<rect fill="blue" width="40" height="48" x="30" y="7" rx="10" ry="10"/>
+	<text fill="black" x="35" y="31" textLength="24" xml:space="preserve">sub</text>
+	<text fill="black" x="35" y="55" textLength="24" xml:space="preserve">Sub</text>
+	<text fill="black" x="75" y="17" textLength="48" xml:space="preserve">(T1, p.T2)</text>
+</svg>
+`
+	specs := []struct {
+		name        string
+		givenLinks  map[string]string
+		givenWords  map[string]string
+		expectedSVG string
+	}{
+		{
+			name:        "no-links",
+			givenLinks:  nil,
+			expectedSVG: givenSVG,
+		}, {
+			name:        "unknown-text",
+			givenLinks:  map[string]string{"other": "other.md"},
+			expectedSVG: givenSVG,
+		}, {
+			name:       "linked-box",
+			givenLinks: map[string]string{"sub": "x.md#flow-sub", "Sub": "x.md#flow-sub"},
+			expectedSVG: `<svg xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100px" height="60px">
+	<rect fill="white" width="100" height="60" x="0" y="0"/>
+	<a xlink:href="x.md#flow-sub"><rect fill="blue" width="40" height="48" x="30" y="7" rx="10" ry="10"/></a>
+	<a xlink:href="x.md#flow-sub"><text fill="black" x="35" y="31" textLength="24" xml:space="preserve">sub</text></a>
+	<a xlink:href="x.md#flow-sub"><text fill="black" x="35" y="55" textLength="24" xml:space="preserve">Sub</text></a>
+	<text fill="black" x="75" y="17" textLength="48" xml:space="preserve">(T1, p.T2)</text>
+</svg>
+`,
+		}, {
+			name:       "linked-data",
+			givenWords: map[string]string{"p.T2": "p/t.go#L3L5", "Sub": "y.md"},
+			expectedSVG: `<svg xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100px" height="60px">
+	<rect fill="white" width="100" height="60" x="0" y="0"/>
+	<rect fill="blue" width="40" height="48" x="30" y="7" rx="10" ry="10"/>
+	<text fill="black" x="35" y="31" textLength="24" xml:space="preserve">sub</text>
+	<text fill="black" x="35" y="55" textLength="24" xml:space="preserve">Sub</text>
+	<text fill="black" x="75" y="17" textLength="48" xml:space="preserve">(T1, <a xlink:href="p/t.go#L3L5">p.T2</a>)</text>
+</svg>
+`,
+		},
+	}
+	for _, spec := range specs {
+		t.Logf("Testing SVG: %s\n", spec.name)
+		got := string(linkSVG([]byte(givenSVG), spec.givenLinks, spec.givenWords))
+		if spec.expectedSVG != got {
+			t.Errorf("Expected SVG:\n%s\ngot:\n%s", spec.expectedSVG, got)
+		}
+	}
+}
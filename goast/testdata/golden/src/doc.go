@@ -0,0 +1,22 @@
+// Package sample shows how flows are documented.
+//
+// flow:
+//
+//	in (Tint1)-> [Bla] -> [Pipeline] -> out
+package sample
+
+// Pipeline is a stateful component.
+//
+// flow:
+//
+//	in (Tint1)-> [pipe] (TBlaer)-> [DoBla] -> out
+type Pipeline struct {
+	b *Blaer
+}
+
+// pipe chains the filters.
+//
+// flow:
+//
+//	in (Tint1)-> [foo1] -> [foo2] -> out
+var pipe = []func(Tint1) Tint1{foo1, foo2}
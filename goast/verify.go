@@ -0,0 +1,331 @@
+package goast
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/flowdev/gflowparser/data"
+)
+
+// flowMismatch is a difference between a flow and the body of the function
+// it documents.
+type flowMismatch struct {
+	flow    string
+	msg     string
+	dslPos  token.Position // position in the flow DSL (invalid if unknown)
+	codePos token.Position // position in the function (invalid if unknown)
+}
+
+func (m flowMismatch) String() string {
+	pos, other := m.dslPos, m.codePos
+	if !pos.IsValid() {
+		pos, other = m.codePos, token.Position{}
+	}
+	s := fmt.Sprintf("%s: flow %s: %s", pos, m.flow, m.msg)
+	if other.IsValid() {
+		s += fmt.Sprintf(" (code at %s)", other)
+	}
+	return s
+}
+
+// verifyComp is a component of a flow with the position of its first
+// appearance in the DSL.
+type verifyComp struct {
+	name    string
+	typ     data.Type
+	plugins []data.Plugin
+	srcPos  int
+	calls   []*ast.CallExpr
+	first   int // index of the first call in the order of execution
+}
+
+// VerifyDirs compares the flows in the given directories with the bodies
+// of the functions they document.
+// The calls of components (including port functions and plugins handed
+// over as arguments) have to match the components of the flow in number
+// and order.
+// All mismatches are written to w and their number is returned.
+func VerifyDirs(dirs []string, w io.Writer) (int, error) {
+	n := 0
+	for _, dir := range dirs {
+		fset := token.NewFileSet()
+		pkgs, err := parser.ParseDir(fset, dir, excludeTests, parser.ParseComments)
+		if err != nil {
+			return n, fmt.Errorf("unable to parse the directory '%s': %w", dir, err)
+		}
+		for _, pkg := range sortedPackages(pkgs) {
+			if isTestPackage(pkg.Name) {
+				continue
+			}
+			mismatches, err := verifyPackage(pkg, dir, fset)
+			if err != nil {
+				return n, err
+			}
+			for _, m := range mismatches {
+				fmt.Fprintln(w, m)
+			}
+			n += len(mismatches)
+		}
+	}
+	return n, nil
+}
+
+func verifyPackage(pkg *ast.Package, dir string, fset *token.FileSet) ([]flowMismatch, error) {
+	partMap := make(map[string]*sourcePart)
+	flows := make([]*sourcePart, 0, 32)
+	decls := make(map[string]*ast.FuncDecl) // by file and line
+	var err error
+	for _, name := range sortedFileNames(pkg.Files) {
+		astf := pkg.Files[name]
+		if flows, err = findSourceParts(partMap, flows, astf, name, "", fset); err != nil {
+			return nil, fmt.Errorf("unable to find all flows in package (%s): %w", pkg.Name, err)
+		}
+		for _, idecl := range astf.Decls {
+			if decl, ok := idecl.(*ast.FuncDecl); ok && decl.Body != nil {
+				decls[fmt.Sprintf("%s:%d", name, lineFor(decl.Pos(), fset))] = decl
+			}
+		}
+	}
+	if flows, err = findFlowFiles(partMap, flows, dir, ""); err != nil {
+		return nil, fmt.Errorf("unable to find all flow files of package (%s): %w", pkg.Name, err)
+	}
+
+	mismatches := make([]flowMismatch, 0, 8)
+	for _, f := range flows {
+		decl := decls[fmt.Sprintf("%s:%d", f.goFile, f.start)]
+		if decl == nil || strings.SplitN(decl.Name.Name, "_", 2)[0] != f.name {
+			continue // the flow doesn't document the function
+		}
+		pFlow, err := parseFlowDSL(f.dsl, f.name)
+		if err != nil {
+			log.Printf("WARNING: Unable to verify flow %s: %v", f.name, err)
+			continue
+		}
+		file, lines := dslLinesFor(f, decl, fset)
+		mismatches = append(mismatches, verifyFlow(f, pFlow, decl, file, lines, fset)...)
+	}
+	return mismatches, nil
+}
+
+// verifyFlow compares the components of the flow with the calls in the
+// body of the function.
+func verifyFlow(
+	f *sourcePart, pFlow data.Flow, decl *ast.FuncDecl,
+	file string, lines []lspLine, fset *token.FileSet,
+) []flowMismatch {
+	comps := flowComponents(pFlow)
+	for i, call := range bodyCalls(decl.Body) {
+		if c := callTarget(call, comps); c != nil {
+			if len(c.calls) == 0 {
+				c.first = i
+			}
+			c.calls = append(c.calls, call)
+		}
+	}
+
+	mismatches := make([]flowMismatch, 0, 4)
+	add := func(c *verifyComp, call ast.Node, msg string) {
+		m := flowMismatch{flow: f.name, msg: msg, dslPos: dslPosition(file, f.dsl, c.srcPos, lines)}
+		if call != nil {
+			m.codePos = fset.Position(call.Pos())
+		}
+		mismatches = append(mismatches, m)
+	}
+	var last *verifyComp
+	for _, c := range comps {
+		if len(c.calls) == 0 {
+			add(c, nil, "component "+c.name+" isn't called")
+			continue
+		}
+		if len(c.calls) > 1 {
+			add(c, c.calls[1], fmt.Sprintf("component %s is called %d times", c.name, len(c.calls)))
+		}
+		if last != nil && c.first < last.first {
+			add(c, c.calls[0], "component "+c.name+" is called before component "+last.name)
+		}
+		for _, p := range c.plugins {
+			if !passesPlugin(c.calls[0], p) {
+				add(c, c.calls[0], "plugin "+pluginName(p)+" isn't handed over to component "+c.name)
+			}
+		}
+		last = c
+	}
+	return mismatches
+}
+
+// flowComponents returns the components of the flow in the order of their
+// first appearance.
+func flowComponents(pFlow data.Flow) []*verifyComp {
+	comps := make([]*verifyComp, 0, 16)
+	compMap := make(map[string]*verifyComp)
+	for _, partLine := range pFlow.Parts {
+		for _, part := range partLine {
+			p, ok := part.(data.Component)
+			if !ok {
+				continue
+			}
+			c := compMap[p.Decl.Name]
+			if c == nil {
+				c = &verifyComp{name: p.Decl.Name, typ: p.Decl.Type, srcPos: p.Decl.SrcPos}
+				compMap[c.name] = c
+				comps = append(comps, c)
+			} else if !p.Decl.VagueType { // explicit declarations win
+				c.typ = p.Decl.Type
+			}
+			if len(p.Plugins) > 0 {
+				c.plugins = p.Plugins
+			}
+		}
+	}
+	return comps
+}
+
+// callTarget returns the component instance that the call belongs to.
+// Instances of the same type get the calls in the order of the DSL and
+// additional calls count for the last one.
+func callTarget(call *ast.CallExpr, comps []*verifyComp) *verifyComp {
+	var last *verifyComp
+	for _, c := range comps {
+		if !callsComponent(call, c.typ) {
+			continue
+		}
+		if len(c.calls) == 0 {
+			return c
+		}
+		last = c
+	}
+	return last
+}
+
+// bodyCalls returns all calls in the body in the order of their execution
+// (arguments are evaluated before the call).
+func bodyCalls(body *ast.BlockStmt) []*ast.CallExpr {
+	calls := make([]*ast.CallExpr, 0, 16)
+	stack := make([]ast.Node, 0, 32)
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n != nil {
+			stack = append(stack, n)
+			return true
+		}
+		n, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		if call, ok := n.(*ast.CallExpr); ok {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	return calls
+}
+
+// callsComponent tells if the call is a call of the component itself, one
+// of its input port functions or one of its methods.
+func callsComponent(call *ast.CallExpr, typ data.Type) bool {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return typ.Package == "" && isComponentFunc(fun.Name, typ.LocalType)
+	case *ast.SelectorExpr:
+		if !isComponentFunc(fun.Sel.Name, typ.LocalType) {
+			return false
+		}
+		x, ok := fun.X.(*ast.Ident)
+		return typ.Package == "" || (ok && x.Name == typ.Package)
+	}
+	return false
+}
+func isComponentFunc(name, comp string) bool {
+	return name == comp || strings.HasPrefix(name, comp+"Port")
+}
+
+// passesPlugin tells if one of the plugin's components is an argument of
+// the call (calls of the components don't count).
+func passesPlugin(call *ast.CallExpr, p data.Plugin) bool {
+	for _, arg := range call.Args {
+		if c, ok := arg.(*ast.CallExpr); ok {
+			if passesPlugin(c, p) {
+				return true
+			}
+			continue
+		}
+		found := false
+		ast.Inspect(arg, func(n ast.Node) bool {
+			var name string
+			switch x := n.(type) {
+			case *ast.CallExpr:
+				found = found || passesPlugin(x, p)
+				return false
+			case *ast.Ident:
+				name = x.Name
+			case *ast.SelectorExpr:
+				name = x.Sel.Name
+			}
+			for _, t := range p.Types {
+				if name != "" && name == t.LocalType {
+					found = true
+				}
+			}
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+func pluginName(p data.Plugin) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	names := make([]string, len(p.Types))
+	for i, t := range p.Types {
+		names[i] = dslTypeToString(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// dslLinesFor maps the DSL lines of the flow to the file containing them.
+func dslLinesFor(f *sourcePart, decl *ast.FuncDecl, fset *token.FileSet) (string, []lspLine) {
+	if f.flowFile != "" {
+		buf, err := ioutil.ReadFile(f.flowFile)
+		if err != nil {
+			return f.flowFile, nil
+		}
+		raws := make([]rawCommentLine, 0, 32)
+		for i, text := range strings.Split(string(buf), "\n") {
+			raws = append(raws, rawCommentLine{line: i, text: text})
+		}
+		lines, _ := mapDSLLines(f.dsl, raws, 0)
+		return f.flowFile, lines
+	}
+	if decl.Doc == nil {
+		return f.goFile, nil
+	}
+	raws := rawCommentLines(decl.Doc, fset)
+	blocks, _ := extractFlows(decl.Doc.Text())
+	next := 0
+	for _, b := range blocks {
+		var lines []lspLine
+		lines, next = mapDSLLines(b.dsl, raws, next)
+		if b.dsl == f.dsl {
+			return f.goFile, lines
+		}
+	}
+	return f.goFile, nil
+}
+
+// dslPosition converts a position in the DSL into a position in the file.
+func dslPosition(file, dsl string, srcPos int, lines []lspLine) token.Position {
+	if srcPos < 0 || srcPos > len(dsl) {
+		return token.Position{}
+	}
+	i := strings.Count(dsl[:srcPos], "\n")
+	col := srcPos - strings.LastIndexByte(dsl[:srcPos], '\n') - 1
+	if i >= len(lines) || lines[i].line < 0 {
+		return token.Position{}
+	}
+	return token.Position{Filename: file, Line: lines[i].line + 1, Column: lines[i].offset + col + 1}
+}
@@ -0,0 +1,106 @@
+package goast_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flowdev/go2md/goast"
+)
+
+func TestVerifyDirs(t *testing.T) {
+	const components = `
+func foo1(i int) int { return i }
+func foo2(i int) int { return i }
+func foo3(i int, plugin func(int) int) int { return plugin(i) }
+`
+	specs := []struct {
+		name          string
+		givenFlow     string
+		givenBody     string
+		expectedLines []string
+	}{
+		{
+			name:          "matching",
+			givenBody:     "return foo3(foo2(foo1(i)), foo1)",
+			expectedLines: []string{},
+		}, {
+			name:      "called-twice",
+			givenBody: "i = foo1(i)\n\ti = foo1(i)\n\treturn foo3(foo2(i), foo1)",
+			expectedLines: []string{
+				"x.go:6:20: flow Bla: component foo1 is called 2 times (code at x.go:13:6)",
+			},
+		}, {
+			name:      "missing-call",
+			givenBody: "return foo3(foo1(i), foo1)",
+			expectedLines: []string{
+				"x.go:6:30: flow Bla: component foo2 isn't called",
+			},
+		}, {
+			name:      "wrong-order",
+			givenBody: "return foo3(foo1(foo2(i)), foo1)",
+			expectedLines: []string{
+				"x.go:6:30: flow Bla: component foo2 is called before component foo1 (code at x.go:12:19)",
+			},
+		}, {
+			name:      "missing-plugin",
+			givenBody: "return foo3(foo2(foo1(i)), nil)",
+			expectedLines: []string{
+				"x.go:6:40: flow Bla: plugin foo1 isn't handed over to component foo3 (code at x.go:12:9)",
+			},
+		}, {
+			name:          "same-type-twice",
+			givenFlow:     "in (int)-> [x foo1] -> [y foo1] -> out",
+			givenBody:     "return foo1(foo1(i))",
+			expectedLines: []string{},
+		}, {
+			name:      "same-type-missing-call",
+			givenFlow: "in (int)-> [x foo1] -> [y foo1] -> out",
+			givenBody: "return foo1(i)",
+			expectedLines: []string{
+				"x.go:6:32: flow Bla: component y isn't called",
+			},
+		},
+	}
+	for _, spec := range specs {
+		t.Run(spec.name, func(t *testing.T) {
+			if spec.givenFlow == "" {
+				spec.givenFlow = "in (int)-> [foo1] -> [foo2] -> [foo3[foo1]] -> out"
+			}
+			dir := t.TempDir()
+			src := `package x
+
+// Bla is a simple filter.
+//
+// flow:
+//     ` + spec.givenFlow + `
+//
+// More doc.
+func Bla(i int) int {
+	fmt.Println(i)
+
+	` + spec.givenBody + `
+}
+` + components
+			if err := ioutil.WriteFile(filepath.Join(dir, "x.go"), []byte(src), os.FileMode(0666)); err != nil {
+				t.Fatalf("Unable to write file: %v", err)
+			}
+			out := &bytes.Buffer{}
+			n, err := goast.VerifyDirs([]string{dir}, out)
+			if err != nil {
+				t.Fatalf("Unable to verify directory: %v", err)
+			}
+			actual := strings.Split(strings.TrimSpace(strings.ReplaceAll(out.String(), dir+string(filepath.Separator), "")), "\n")
+			if n == 0 {
+				actual = []string{}
+			}
+			if n != len(spec.expectedLines) || strings.Join(actual, "\n") != strings.Join(spec.expectedLines, "\n") {
+				t.Errorf("Expected %d mismatches:\n%s\nbut got %d:\n%s",
+					len(spec.expectedLines), strings.Join(spec.expectedLines, "\n"), n, strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package goast
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watch processes the given directories and processes them again whenever
+// a Go or flow file in them changes.
+// The directories are polled in the given interval until stop is closed.
+// Only flows in changed files are converted again and only changed
+// documentation files are written.
+// Errors are reported as they occur and don't stop watching.
+func Watch(dirs []string, packDict *packageDict, interval time.Duration, stop <-chan struct{}) error {
+	watchDirs(dirs, packDict, interval, stop, func(err error) {
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return
+		}
+		fmt.Println("Documentation is up to date.")
+	})
+	return nil
+}
+
+// watchDirs processes the directories initially and whenever a Go file in
+// them changes. The function done is called after each run.
+func watchDirs(dirs []string, packDict *packageDict, interval time.Duration, stop <-chan struct{},
+	done func(err error),
+) {
+	if packDict.cache == nil && !packDict.opts.Cache { // else the first run loads the cache file
+		packDict.cache = newFlowCache("", packDict.opts) // in memory only
+	}
+	snaps := make([]dirSnapshot, len(dirs))
+	for i, dir := range dirs {
+		snaps[i] = takeSnapshot(dir)
+	}
+	done(ProcessDirs(dirs, packDict))
+	fmt.Println("Watching for changes in:", strings.Join(dirs, ", "))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		changed := make([]string, 0, len(dirs))
+		for i, dir := range dirs {
+			snap := takeSnapshot(dir)
+			if files := snap.changedFiles(snaps[i]); len(files) > 0 {
+				for _, file := range files {
+					fmt.Println("Changed:", file)
+				}
+				changed = append(changed, dir)
+			}
+			snaps[i] = snap
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		if packDict.model != nil || packDict.overview != nil {
+			changed = dirs // project-wide documents need all directories
+		}
+		done(ProcessDirs(changed, packDict))
+	}
+}
+
+// dirSnapshot contains the state of all Go files of a directory.
+type dirSnapshot struct {
+	dir   string
+	files map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func takeSnapshot(dir string) dirSnapshot {
+	snap := dirSnapshot{dir: dir, files: make(map[string]fileState)}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("ERROR: Unable to read directory '%s': %v", dir, err)
+		return snap
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !isSourceFile(name) {
+			continue
+		}
+		snap.files[name] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return snap
+}
+
+// isSourceFile tells if the file can contain flows.
+func isSourceFile(name string) bool {
+	return (strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, goTestFileName)) ||
+		strings.HasSuffix(name, flowFileExt)
+}
+
+// changedFiles returns all files that have been added, changed or removed
+// since the old snapshot.
+func (snap dirSnapshot) changedFiles(old dirSnapshot) []string {
+	changed := make([]string, 0, 8)
+	for name, state := range snap.files {
+		if oldState, ok := old.files[name]; !ok || oldState != state {
+			changed = append(changed, filepath.Join(snap.dir, name))
+		}
+	}
+	for name := range old.files {
+		if _, ok := snap.files[name]; !ok {
+			changed = append(changed, filepath.Join(snap.dir, name))
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
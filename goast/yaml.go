@@ -0,0 +1,103 @@
+package goast
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// toYAML converts a struct into YAML.
+// Only the subset of YAML needed for the model is supported: structs
+// (using their JSON field names), slices and scalars.
+// Scalars are written in JSON syntax since JSON scalars are valid YAML.
+func toYAML(v interface{}) ([]byte, error) {
+	rv := deref(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unable to convert %s to YAML, a struct is needed", rv.Kind())
+	}
+	b := &bytes.Buffer{}
+	if err := writeYAMLStruct(b, rv, 0, false); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func writeYAMLStruct(b *bytes.Buffer, v reflect.Value, indent int, inline bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, omitEmpty := jsonName(t.Field(i))
+		fv := v.Field(i)
+		if name == "" || (omitEmpty && fv.IsZero()) {
+			continue
+		}
+		if !inline {
+			b.WriteString(strings.Repeat("  ", indent))
+		}
+		inline = false
+		b.WriteString(name + ":")
+		if err := writeYAMLValue(b, fv, indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func writeYAMLValue(b *bytes.Buffer, v reflect.Value, indent int) error {
+	v = deref(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		b.WriteString("\n")
+		return writeYAMLStruct(b, v, indent+1, false)
+	case reflect.Slice:
+		if v.Len() == 0 {
+			b.WriteString(" []\n")
+			return nil
+		}
+		b.WriteString("\n")
+		for i := 0; i < v.Len(); i++ {
+			b.WriteString(strings.Repeat("  ", indent+1) + "-")
+			e := deref(v.Index(i))
+			if e.Kind() == reflect.Struct {
+				b.WriteString(" ")
+				if err := writeYAMLStruct(b, e, indent+2, true); err != nil {
+					return err
+				}
+			} else if err := writeYAMLValue(b, e, indent+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := marshalJSON(v.Interface(), "")
+	if err != nil {
+		return err
+	}
+	b.WriteString(" ")
+	b.Write(s)
+	return nil
+}
+func jsonName(f reflect.StructField) (name string, omitEmpty bool) {
+	if f.PkgPath != "" { // unexported
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
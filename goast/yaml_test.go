@@ -0,0 +1,48 @@
+package goast
+
+import (
+	"testing"
+)
+
+func TestToYAML(t *testing.T) {
+	type inner struct {
+		Name  string `json:"name"`
+		Count int    `json:"count,omitempty"`
+	}
+	type outer struct {
+		Version int      `json:"version"`
+		Text    string   `json:"text"`
+		Tags    []string `json:"tags"`
+		Empty   []string `json:"empty"`
+		Inners  []*inner `json:"inners"`
+		Single  inner    `json:"single"`
+		ignored int
+	}
+	given := &outer{
+		Version: 1,
+		Text:    "in (a)-> [b] -> out\n",
+		Tags:    []string{"x", "y"},
+		Inners:  []*inner{{Name: "i1", Count: 3}, {Name: "i2"}},
+		Single:  inner{Name: "s"},
+	}
+	expected := `version: 1
+text: "in (a)-> [b] -> out\n"
+tags:
+  - "x"
+  - "y"
+empty: []
+inners:
+  - name: "i1"
+    count: 3
+  - name: "i2"
+single:
+  name: "s"
+`
+	got, err := toYAML(given)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected != string(got) {
+		t.Errorf("Expected YAML:\n%s\ngot:\n%s", expected, got)
+	}
+}
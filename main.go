@@ -6,33 +6,272 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/flowdev/go2md/goast"
 )
 
 var localLinks bool
+var format string
+var embed string
+var diagram string
+var exports string
+var packageGraph bool
+var overview bool
+var subflowLinks bool
+var svgLinks bool
+var signatures bool
+var dataTypes bool
+var portRefs bool
+var cache bool
+var clean bool
+var dryRun bool
+var jobs int
+var interval time.Duration
+var addr string
+var write bool
 
 func init() {
 	const (
 		localLinksDefault = false
 		localLinksUsage   = "create links to local files in markdown"
+		formatDefault     = goast.FormatMarkdown
+		formatUsage       = "output format: 'md' (Markdown), 'html', 'json' or 'yaml'"
+		embedDefault      = goast.EmbedNone
+		embedUsage        = "embed SVG diagrams into Markdown: 'inline' or 'datauri'"
+		diagramDefault    = goast.DiagramSVG
+		diagramUsage      = "type of flow diagrams: 'svg' or 'mermaid' (Markdown only)"
+		exportsDefault    = ""
+		exportsUsage      = "comma separated list of additional export formats: 'dot', 'puml'"
+		packGraphDefault  = false
+		packGraphUsage    = "export the flows of the whole package into one graph, too"
+		overviewDefault   = false
+		overviewUsage     = "write an overview of the usage of all flows (flow-overview.md)"
+		subLinksDefault   = false
+		subLinksUsage     = "make sub-flows in SVG diagrams clickable (best with '-embed inline' or HTML)"
+		svgLinksDefault   = false
+		svgLinksUsage     = "make all components and data types in SVG diagrams clickable"
+		sigsDefault       = false
+		sigsUsage         = "add a table with the ports, plugins and docs of the components"
+		dataTypesDefault  = false
+		dataTypesUsage    = "add the definitions of the data types (fields, methods, ...)"
+		portRefsDefault   = false
+		portRefsUsage     = "list the data types per port of each component instead of the reference table"
+		cacheDefault      = false
+		cacheUsage        = "cache converted flows in '.go2md-cache' and remove stale files"
+		cleanDefault      = false
+		cleanUsage        = "remove stale generated files (e.g. of deleted flows)"
+		dryRunDefault     = false
+		dryRunUsage       = "only list the files that would be written or removed"
+		jobsUsage         = "maximum number of concurrent workers"
+		intervalDefault   = time.Second
+		intervalUsage     = "interval for polling the directories (watch and serve only)"
+		addrDefault       = "localhost:8080"
+		addrUsage         = "address of the preview server (serve only)"
+		writeDefault      = false
+		writeUsage        = "insert the suggested flows into the doc comments (suggest only)"
 	)
 	flag.BoolVar(&localLinks, "local", localLinksDefault, localLinksUsage)
 	flag.BoolVar(&localLinks, "l", localLinksDefault, localLinksUsage+" (shorthand)")
+	flag.StringVar(&format, "format", formatDefault, formatUsage)
+	flag.StringVar(&format, "f", formatDefault, formatUsage+" (shorthand)")
+	flag.StringVar(&embed, "embed", embedDefault, embedUsage)
+	flag.StringVar(&diagram, "diagram", diagramDefault, diagramUsage)
+	flag.StringVar(&exports, "export", exportsDefault, exportsUsage)
+	flag.BoolVar(&packageGraph, "package-graph", packGraphDefault, packGraphUsage)
+	flag.BoolVar(&overview, "overview", overviewDefault, overviewUsage)
+	flag.BoolVar(&subflowLinks, "subflow-links", subLinksDefault, subLinksUsage)
+	flag.BoolVar(&svgLinks, "svg-links", svgLinksDefault, svgLinksUsage)
+	flag.BoolVar(&signatures, "signatures", sigsDefault, sigsUsage)
+	flag.BoolVar(&dataTypes, "data-types", dataTypesDefault, dataTypesUsage)
+	flag.BoolVar(&portRefs, "port-refs", portRefsDefault, portRefsUsage)
+	flag.BoolVar(&cache, "cache", cacheDefault, cacheUsage)
+	flag.BoolVar(&clean, "clean", cleanDefault, cleanUsage)
+	flag.BoolVar(&dryRun, "dry-run", dryRunDefault, dryRunUsage)
+	flag.IntVar(&jobs, "j", runtime.NumCPU(), jobsUsage)
+	flag.DurationVar(&interval, "interval", intervalDefault, intervalUsage)
+	flag.StringVar(&addr, "addr", addrDefault, addrUsage)
+	flag.BoolVar(&write, "write", writeDefault, writeUsage)
+	flag.Usage = usage
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags] [directories]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  watch    regenerate the documentation whenever a Go file changes")
+	fmt.Fprintln(out, "  serve    preview the documentation in the browser (nothing is written)")
+	fmt.Fprintln(out, "  lsp      run a language server for flows in Go comments (stdio)")
+	fmt.Fprintln(out, "  fmt      format the flows in Go comments (compatible with gofmt)")
+	fmt.Fprintln(out, "  scaffold generate Go function stubs for missing components of flows")
+	fmt.Fprintln(out, "  verify   check that the bodies of flow functions match their flows")
+	fmt.Fprintln(out, "  suggest  suggest flows for functions without one (see '-write')")
+	fmt.Fprintln(out, "\nWithout a command the documentation is generated once.")
+	fmt.Fprintln(out, "Directories ending in '/...' include all subdirectories.\n\nFlags:")
+	flag.PrintDefaults()
 }
 
 func main() {
-	flag.Parse()
+	cmd, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && isCommand(args[0]) {
+		cmd, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if cmd == "serve" {
+		format = goast.FormatHTML // the server always shows HTML pages
+	}
+	if cmd == "fmt" {
+		if err := goast.FormatDirs(expandDirs(flag.Args()), dryRun); err != nil {
+			log.Fatalf("FATAL: Unable to format flows: %v", err)
+		}
+		return
+	}
+	if cmd == "scaffold" {
+		if err := goast.ScaffoldDirs(expandDirs(flag.Args()), dryRun); err != nil {
+			log.Fatalf("FATAL: Unable to scaffold components: %v", err)
+		}
+		return
+	}
+	if cmd == "verify" {
+		n, err := goast.VerifyDirs(expandDirs(flag.Args()), os.Stdout)
+		if err != nil {
+			log.Fatalf("FATAL: Unable to verify flows: %v", err)
+		}
+		if n > 0 {
+			log.Fatalf("FATAL: Found %d mismatches between flows and code.", n)
+		}
+		return
+	}
+	if cmd == "suggest" {
+		if err := goast.SuggestDirs(expandDirs(flag.Args()), write, os.Stdout); err != nil {
+			log.Fatalf("FATAL: Unable to suggest flows: %v", err)
+		}
+		return
+	}
+	stdout := os.Stdout
+	if cmd == "lsp" {
+		os.Stdout = os.Stderr // stdout is reserved for the protocol
+	}
 	srcRoots := findSourceRoots()
 	projRoot := getOutputOfCmd("git", "rev-parse", "--show-toplevel")
 	fmt.Println("srcRoots:", srcRoots)
 	fmt.Println("localLinks:", localLinks)
+	fmt.Println("format:", format)
+	fmt.Println("embed:", embed)
+	fmt.Println("diagram:", diagram)
+	fmt.Println("export:", exports)
 	fmt.Println("projRoot:", projRoot)
-	if err := goast.ProcessDir(".", goast.NewPackageDict(srcRoots, projRoot, localLinks)); err != nil {
-		log.Printf("FATAL: Unable to process current directory: %v", err)
+	packDict, err := goast.NewPackageDict(srcRoots, projRoot, goast.Options{
+		LocalLinks:   localLinks,
+		Format:       format,
+		Embed:        embed,
+		Diagram:      diagram,
+		Exports:      splitList(exports),
+		PackageGraph: packageGraph,
+		Overview:     overview,
+		SubflowLinks: subflowLinks,
+		SVGLinks:     svgLinks,
+		Signatures:   signatures,
+		DataTypes:    dataTypes,
+		PortRefs:     portRefs,
+		Cache:        cache,
+		Clean:        clean,
+		DryRun:       dryRun,
+		Jobs:         jobs,
+	})
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
 	}
+	dirs := expandDirs(flag.Args())
+	switch cmd {
+	case "watch":
+		if err := goast.Watch(dirs, packDict, interval, interrupted()); err != nil {
+			log.Fatalf("FATAL: Unable to watch directories %v: %v", dirs, err)
+		}
+	case "lsp":
+		if err := goast.LSP(os.Stdin, stdout, packDict); err != nil {
+			log.Fatalf("FATAL: Language server failed: %v", err)
+		}
+	case "serve":
+		if err := goast.Serve(addr, dirs, packDict, interval, interrupted()); err != nil {
+			log.Fatalf("FATAL: Unable to serve directories %v: %v", dirs, err)
+		}
+	default:
+		if err := goast.ProcessDirs(dirs, packDict); err != nil {
+			log.Printf("FATAL: Unable to process directories %v: %v", dirs, err)
+		}
+	}
+}
+
+func isCommand(arg string) bool {
+	switch arg {
+	case "watch", "serve", "lsp", "fmt", "scaffold", "verify", "suggest":
+		return true
+	}
+	return false
+}
+
+// interrupted returns a channel that is closed as soon as the program is
+// interrupted.
+func interrupted() <-chan struct{} {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+	return stop
+}
+
+// expandDirs expands the directory arguments.
+// A trailing '/...' includes all subdirectories containing Go files
+// (except vendor, testdata and hidden directories).
+// Without arguments the current directory is used.
+func expandDirs(args []string) []string {
+	if len(args) == 0 {
+		return []string{"."}
+	}
+	dirs := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "..." && !strings.HasSuffix(arg, "/...") {
+			dirs = append(dirs, arg)
+			continue
+		}
+		root := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+		if root == "" {
+			root = "."
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			name := info.Name()
+			if path != root && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if hasGoFiles(path) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("FATAL: Unable to walk directory '%s': %v", root, err)
+		}
+	}
+	return dirs
+}
+func hasGoFiles(dir string) bool {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	return err == nil && len(files) > 0
 }
 
 // findSourceRoots finds all the possible roots for Go source code in the right
@@ -68,6 +307,17 @@ func findGoRootRoot() string {
 	return filepath.Join(getOutputOfCmd("go", "env", "GOROOT"), "src")
 }
 
+// splitList splits a comma separated list and ignores empty entries.
+func splitList(list string) []string {
+	result := make([]string, 0, 4)
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func getOutputOfCmd(cmd string, args ...string) string {
 	out, err := exec.Command(cmd, args...).Output()
 	if err != nil {